@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// AccountConfig holds per-account settings from `[accounts.<name>]` sections.
+// Accounts without a section authenticate with the `[google]` OAuth client.
+type AccountConfig struct {
+	Type              string   `toml:"type"`                         // oauth (default) or service_account
+	ClientID          string   `toml:"client_id"`                    // OAuth client overriding `[google]`
+	ClientSecret      string   `toml:"client_secret"`                // falls back to client_secret/<name> in the secret store
+	Scopes            []string `toml:"scopes"`                       // OAuth scopes, derived from calendar roles by default
+	AuthFlow          string   `toml:"auth_flow"`                    // loopback (default) or device
+	ServiceAccountKey string   `toml:"service_account_key"`          // path to the service-account JSON key
+	Subject           string   `toml:"subject"`                      // user to impersonate via domain-wide delegation
+	RequestsPerSecond float64  `toml:"requests_per_second,omitzero"` // overrides [general] requests_per_second
+	RequestBurst      int      `toml:"request_burst,omitzero"`       // overrides [general] request_burst
+}
+
+// Ways an OAuth account can be authorized
+const (
+	authFlowLoopback = "loopback"
+	authFlowDevice   = "device"
+)
+
+// checkAccounts rejects account settings with unknown values, which would
+// otherwise silently fall back to the default.
+func checkAccounts(config *Config) error {
+	for name, account := range config.Accounts {
+		switch account.AuthFlow {
+		case "", authFlowLoopback, authFlowDevice:
+		default:
+			return fmt.Errorf("unknown auth_flow %q for account %s, use %s or %s", account.AuthFlow, name, authFlowLoopback, authFlowDevice)
+		}
+	}
+	return nil
+}
+
+func accountClientSecretKey(accountName string) string {
+	return "client_secret/" + accountName
+}
+
+func serviceAccountKeyKey(accountName string) string {
+	return "service_account_key/" + accountName
+}
+
+func (cfg *Config) account(accountName string) AccountConfig {
+	return cfg.Accounts[accountName]
+}
+
+func (cfg *Config) isServiceAccount(accountName string) bool {
+	return cfg.account(accountName).Type == "service_account"
+}
+
+// oauthConfigFor returns the OAuth client used for accountName: the global one
+// built from `[google]`, with the account's client and scopes applied on top.
+func oauthConfigFor(cfg *Config, accountName string) *oauth2.Config {
+	account := cfg.account(accountName)
+	config := *oauthConfig
+	config.Scopes = append([]string{}, oauthConfig.Scopes...)
+
+	if account.ClientID != "" {
+		config.ClientID = account.ClientID
+		config.ClientSecret = account.ClientSecret
+		if config.ClientSecret == "" {
+			clientSecret, err := secretStore.Get(accountClientSecretKey(accountName))
+			if err != nil && !errors.Is(err, errSecretNotFound) {
+				log.Fatalf("Error reading client secret for account %s from secret store: %v", accountName, err)
+			}
+			config.ClientSecret = clientSecret
+		}
+	}
+	if len(account.Scopes) > 0 {
+		config.Scopes = append([]string{}, account.Scopes...)
+	}
+	return &config
+}
+
+// getServiceAccountClient builds an HTTP client that authenticates with a
+// service-account key and impersonates the configured subject. The key is read
+// from service_account_key if set, otherwise from the secret store.
+func getServiceAccountClient(ctx context.Context, accountName string, account AccountConfig, scopes []string) (*http.Client, error) {
+	if account.Subject == "" {
+		return nil, fmt.Errorf("service account %s has no subject to impersonate", accountName)
+	}
+
+	var keyJSON []byte
+	if account.ServiceAccountKey != "" {
+		data, err := os.ReadFile(account.ServiceAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		keyJSON = data
+	} else {
+		data, err := secretStore.Get(serviceAccountKeyKey(accountName))
+		if errors.Is(err, errSecretNotFound) {
+			return nil, fmt.Errorf("no service account key configured for account %s", accountName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keyJSON = []byte(data)
+	}
+
+	jwtConfig, err := google.JWTConfigFromJSON(keyJSON, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing service account key for account %s: %v", accountName, err)
+	}
+	jwtConfig.Subject = account.Subject
+	return jwtConfig.Client(ctx), nil
+}
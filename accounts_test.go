@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigChecksAuthFlow(t *testing.T) {
+	tests := []struct {
+		authFlow string
+		wantErr  bool
+	}{
+		{"", false},
+		{"loopback", false},
+		{"device", false},
+		{"devices", true},
+		{"Device", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.authFlow, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), ".gcalsync.toml")
+			data := "[google]\nclient_id = \"id\"\n\n[accounts.work]\nauth_flow = \"" + tt.authFlow + "\"\n"
+			if err := os.WriteFile(filename, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := readConfig(filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readConfig error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "work") {
+				t.Errorf("error %q doesn't name the account", err)
+			}
+		})
+	}
+}
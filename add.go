@@ -4,52 +4,171 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 )
 
-func addCalendar() {
-	config, err := readConfig(".gcalsync.toml")
+// addCalendar adds calendars for an account. Anything not given on the
+// command line is asked for interactively; with discover the account's
+// calendar list is shown to pick from instead of typing raw calendar IDs.
+func addCalendar(accountName string, calendarIDs []string, role string, discover bool) {
+	config, err := readConfig(configFile)
 	if err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
 
-	// Initialize the global oauthConfig
-	initOAuthConfig(config)
-
-	db, err := openDB(".gcalsync.db")
+	db, err := openDB(dbFile)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
 
 	fmt.Println("🚀 Starting calendar addition...")
-	fmt.Print("👤 Enter account name: ")
-	var accountName string
-	fmt.Scanln(&accountName)
+	if accountName == "" {
+		fmt.Print("👤 Enter account name: ")
+		fmt.Scanln(&accountName)
+	}
 
-	fmt.Print("📅 Enter calendar ID: ")
-	var calendarID string
-	fmt.Scanln(&calendarID)
+	// Calendars given on the command line mean a script is running us, so
+	// does input that isn't a terminal: don't ask for what has a default
+	scripted := len(calendarIDs) > 0 || !stdinIsTerminal()
+
+	if !discover && len(calendarIDs) == 0 {
+		fmt.Print("📅 Enter calendar ID: ")
+		var calendarID string
+		fmt.Scanln(&calendarID)
+		calendarIDs = append(calendarIDs, calendarID)
+	}
+
+	if role == "" && scripted {
+		role = roleBoth
+	}
+	if role == "" {
+		fmt.Print("🎭 Enter calendar role (both, source, sink) [both]: ")
+		fmt.Scanln(&role)
+		if role == "" {
+			role = roleBoth
+		}
+	}
+	if !isValidRole(role) {
+		log.Fatalf("Unknown calendar role: %s", role)
+	}
 
 	ctx := context.Background()
 
-	client := getClient(ctx, oauthConfig, db, accountName, config)
+	// Ask only for the scopes the account needs once this calendar is added,
+	// plus reading the calendar list when discovering or checking that a
+	// calendar can receive blockers
+	scopes := accountScopes(append(accountRolesFromDB(db, accountName), role))
+	if discover || isSink(role) {
+		scopes = append(scopes, calendar.CalendarReadonlyScope)
+	}
+	client, err := getClientWithScopes(ctx, accountName, config, scopes)
+	if err != nil {
+		log.Fatalf("Error authorizing account %s: %v", accountName, err)
+	}
 
 	calendarService, err := calendar.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		log.Fatalf("Error creating calendar client: %v", err)
 	}
 
-	_, err = calendarService.CalendarList.Get(calendarID).Do()
-	if err != nil {
-		log.Fatalf("Error retrieving calendar: %v", err)
+	summaries := make(map[string]string)
+	if discover {
+		calendarIDs, summaries = discoverCalendars(calendarService, role)
+	} else {
+		for _, calendarID := range calendarIDs {
+			if isSink(role) {
+				entry, err := calendarService.CalendarList.Get(calendarID).Fields("accessRole").Do()
+				if err != nil {
+					log.Fatalf("Error retrieving calendar %s: %v", calendarID, err)
+				}
+				if !canReceiveBlockers(entry.AccessRole) {
+					log.Fatalf("Calendar %s is %s only, it can't receive blockers. Add it with --role source", calendarID, entry.AccessRole)
+				}
+			}
+			events, err := calendarService.Events.List(calendarID).MaxResults(1).Fields("summary").Do()
+			if err != nil {
+				log.Fatalf("Error retrieving calendar %s: %v", calendarID, err)
+			}
+			summaries[calendarID] = events.Summary
+		}
 	}
-	_, err = db.Exec(`INSERT INTO calendars (account_name, calendar_id) VALUES (?, ?)`, accountName, calendarID)
-	if err != nil {
-		log.Fatalf("Error saving calendar ID: %v", err)
+
+	for _, calendarID := range calendarIDs {
+		_, err = db.Exec(`INSERT INTO calendars (account_name, calendar_id, role, summary) VALUES (?, ?, ?, ?)`, accountName, calendarID, role, summaries[calendarID])
+		if err != nil {
+			log.Fatalf("Error saving calendar ID: %v", err)
+		}
+		fmt.Printf("✅ Calendar %s added successfully for account %s as %s\n", calendarID, accountName, role)
+	}
+}
+
+// discoverCalendars lists the account's calendars and lets the user pick one
+// or more of them, returning their IDs and summaries. Calendars receiving
+// blockers must be writable.
+func discoverCalendars(calendarService *calendar.Service, role string) ([]string, map[string]string) {
+	var entries []*calendar.CalendarListEntry
+	pageToken := ""
+	for {
+		list, err := calendarService.CalendarList.List().PageToken(pageToken).Do()
+		if err != nil {
+			log.Fatalf("Error retrieving calendar list: %v", err)
+		}
+		entries = append(entries, list.Items...)
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	if len(entries) == 0 {
+		log.Fatalf("No calendars found for this account")
+	}
+
+	fmt.Println("📋 Calendars available for this account:")
+	for i, entry := range entries {
+		primary := ""
+		if entry.Primary {
+			primary = " ⭐️ primary"
+		}
+		fmt.Printf("  %2d. %s (%s)%s — %s\n", i+1, entry.Summary, entry.AccessRole, primary, entry.Id)
 	}
 
-	fmt.Printf("✅ Calendar %s added successfully for account %s\n", calendarID, accountName)
+	fmt.Print("🔢 Enter the numbers of the calendars to add, separated by commas: ")
+	var answer string
+	fmt.Scanln(&answer)
+
+	var calendarIDs []string
+	summaries := make(map[string]string)
+	for _, field := range strings.Split(answer, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > len(entries) {
+			log.Fatalf("Invalid calendar number: %s", field)
+		}
+		entry := entries[n-1]
+		if isSink(role) && !canReceiveBlockers(entry.AccessRole) {
+			log.Fatalf("Calendar %s is %s only, it can't receive blockers. Add it with --role source", entry.Summary, entry.AccessRole)
+		}
+		calendarIDs = append(calendarIDs, entry.Id)
+		summaries[entry.Id] = entry.Summary
+		if entry.SummaryOverride != "" {
+			summaries[entry.Id] = entry.SummaryOverride
+		}
+	}
+	return calendarIDs, summaries
+}
+
+// canReceiveBlockers tells whether a calendar list access role allows
+// writing blockers.
+func canReceiveBlockers(accessRole string) bool {
+	return accessRole == "owner" || accessRole == "writer"
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Audit actions, one per kind of calendar mutation
+const (
+	auditInsert = "insert"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// auditEntry describes one mutation made to a calendar. Before and After are
+// snapshots of the event, when known.
+type auditEntry struct {
+	ID               int64           `json:"id"`
+	Timestamp        string          `json:"timestamp"`
+	RunID            int64           `json:"run_id,omitempty"`
+	AccountName      string          `json:"account_name"`
+	CalendarID       string          `json:"calendar_id"`
+	EventID          string          `json:"event_id"`
+	OriginCalendarID string          `json:"origin_calendar_id,omitempty"`
+	OriginEventID    string          `json:"origin_event_id,omitempty"`
+	Action           string          `json:"action"`
+	Before           *calendar.Event `json:"before,omitempty"`
+	After            *calendar.Event `json:"after,omitempty"`
+}
+
+// recordAudit appends a mutation to the audit_log table. Failing to audit is
+// reported but doesn't stop the run, the mutation has already happened.
+func recordAudit(db sqlExecer, entry auditEntry) {
+	// Stored in UTC, so that comparing timestamps as strings orders them
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if currentRun != nil {
+		entry.RunID = currentRun.ID
+	}
+	_, err := db.Exec(`INSERT INTO audit_log
+		(timestamp, run_id, account_name, calendar_id, event_id, origin_calendar_id, origin_event_id, action, before, after)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Timestamp, entry.RunID, entry.AccountName, entry.CalendarID, entry.EventID,
+		entry.OriginCalendarID, entry.OriginEventID, entry.Action, eventSnapshot(entry.Before), eventSnapshot(entry.After))
+	if err != nil {
+		if currentRun != nil {
+			currentRun.fail(err, "Error recording audit log entry for event %s", entry.EventID)
+		} else {
+			log.Printf("Error recording audit log entry: %v\n", err)
+		}
+	}
+}
+
+func eventSnapshot(event *calendar.Event) string {
+	if event == nil {
+		return ""
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func parseEventSnapshot(snapshot string) *calendar.Event {
+	if snapshot == "" {
+		return nil
+	}
+	var event calendar.Event
+	if err := json.Unmarshal([]byte(snapshot), &event); err != nil {
+		return nil
+	}
+	return &event
+}
+
+// describeEvent gives a one-line summary of an event snapshot.
+func describeEvent(event *calendar.Event) string {
+	if event == nil {
+		return "-"
+	}
+	when := func(t *calendar.EventDateTime) string {
+		if t == nil {
+			return "?"
+		}
+		if t.DateTime != "" {
+			return t.DateTime
+		}
+		return t.Date
+	}
+	return fmt.Sprintf("%q %s → %s", event.Summary, when(event.Start), when(event.End))
+}
+
+type auditFilter struct {
+	CalendarID string
+	EventID    string
+	RunID      int64
+	Since      string
+	Until      string
+	Limit      int
+}
+
+func queryAudit(db *sql.DB, filter auditFilter) []auditEntry {
+	var conditions []string
+	var args []any
+	if filter.CalendarID != "" {
+		conditions = append(conditions, "(calendar_id = ? OR origin_calendar_id = ?)")
+		args = append(args, filter.CalendarID, filter.CalendarID)
+	}
+	if filter.EventID != "" {
+		conditions = append(conditions, "(event_id = ? OR origin_event_id = ?)")
+		args = append(args, filter.EventID, filter.EventID)
+	}
+	if filter.RunID != 0 {
+		conditions = append(conditions, "run_id = ?")
+		args = append(args, filter.RunID)
+	}
+	if filter.Since != "" {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until != "" {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.Until)
+	}
+	query := `SELECT id, timestamp, run_id, account_name, calendar_id, event_id, origin_calendar_id, origin_event_id,
+		action, before, after FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Fatalf("❌ Error retrieving audit log: %v", err)
+	}
+	defer rows.Close()
+	entries := []auditEntry{}
+	for rows.Next() {
+		var entry auditEntry
+		var before, after string
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.RunID, &entry.AccountName, &entry.CalendarID, &entry.EventID,
+			&entry.OriginCalendarID, &entry.OriginEventID, &entry.Action, &before, &after); err != nil {
+			log.Fatalf("❌ Error scanning audit log row: %v", err)
+		}
+		entry.Before = parseEventSnapshot(before)
+		entry.After = parseEventSnapshot(after)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// parseAuditTime accepts either an RFC 3339 timestamp or a plain date, in
+// local time, and converts it to UTC like the stored timestamps.
+func parseAuditTime(value string) string {
+	if value == "" {
+		return ""
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC().Format(time.RFC3339)
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		log.Fatalf("Invalid time %q, use YYYY-MM-DD or RFC 3339", value)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func showAudit(filter auditFilter) {
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	entries := queryAudit(db, filter)
+	if outputFormat == "json" {
+		printJSON(entries)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tRUN\tACTION\tACCOUNT\tCALENDAR\tEVENT\tORIGIN\tBEFORE\tAFTER")
+	for _, entry := range entries {
+		origin := "-"
+		if entry.OriginEventID != "" {
+			origin = entry.OriginCalendarID + "/" + entry.OriginEventID
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Timestamp, entry.RunID, entry.Action, entry.AccountName,
+			entry.CalendarID, entry.EventID, origin, describeEvent(entry.Before), describeEvent(entry.After))
+	}
+	w.Flush()
+}
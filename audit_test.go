@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryAuditComparesInstants(t *testing.T) {
+	db := openTestDB(t)
+	// 12:00 UTC is 14:00 in UTC+2
+	for _, timestamp := range []string{"2026-10-18T12:00:00Z", "2026-10-18T13:00:00Z"} {
+		_, err := db.Exec(`INSERT INTO audit_log
+			(timestamp, run_id, account_name, calendar_id, event_id, origin_calendar_id, origin_event_id, action, before, after)
+			VALUES (?, 0, '', '', ?, '', '', ?, '', '')`, timestamp, timestamp, auditInsert)
+		if err != nil {
+			t.Fatalf("insert audit entry: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		since, until string
+		want         []string
+	}{
+		{"since in UTC", "2026-10-18T12:30:00Z", "", []string{"2026-10-18T13:00:00Z"}},
+		{"since with offset", "2026-10-18T14:30:00+02:00", "", []string{"2026-10-18T13:00:00Z"}},
+		{"until with offset", "", "2026-10-18T14:30:00+02:00", []string{"2026-10-18T12:00:00Z"}},
+		{"since with offset west of UTC", "2026-10-18T07:00:00-05:00", "", []string{"2026-10-18T13:00:00Z", "2026-10-18T12:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := queryAudit(db, auditFilter{Since: parseAuditTime(tt.since), Until: parseAuditTime(tt.until)})
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.EventID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordAuditStoresUTC(t *testing.T) {
+	db := openTestDB(t)
+	recordAudit(db, auditEntry{EventID: "blocker", Action: auditInsert})
+	entries := queryAudit(db, auditFilter{})
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	parsed, err := time.Parse(time.RFC3339, entries[0].Timestamp)
+	if err != nil || parsed.Location() != time.UTC {
+		t.Errorf("timestamp %q isn't in UTC", entries[0].Timestamp)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// The Calendar API takes up to 50 calls in one batch request
+const (
+	batchEndpoint = "https://www.googleapis.com/batch/calendar/v3"
+	maxBatchSize  = 50
+)
+
+// batchRequest is one call sent as part of a batch request.
+type batchRequest struct {
+	Method string
+	Path   string
+	Event  *calendar.Event // request body, if any
+}
+
+// batchResult is the answer to one batchRequest: the event Google sent back,
+// if any, or the error of that call.
+type batchResult struct {
+	Event *calendar.Event
+	Err   error
+}
+
+var errNoBatchResponse = errors.New("no response in batch")
+
+func eventRequest(method, calendarID, eventID string, event *calendar.Event) batchRequest {
+	path := "/calendar/v3/calendars/" + url.PathEscape(calendarID) + "/events"
+	if eventID != "" {
+		path += "/" + url.PathEscape(eventID)
+	}
+	return batchRequest{Method: method, Path: path, Event: event}
+}
+
+// runBatch sends requests in batches of maxBatchSize and returns their
+// results in the same order. Calls failing with rate limit or transient
+// errors are sent again in a new batch, following apiRetry; the others are
+// never repeated.
+func runBatch(client *http.Client, requests []batchRequest) []batchResult {
+	results := make([]batchResult, len(requests))
+	pending := make([]int, len(requests))
+	for i := range requests {
+		pending[i] = i
+	}
+
+	start := time.Now()
+	for attempt := 0; len(pending) > 0; attempt++ {
+		for first := 0; first < len(pending); first += maxBatchSize {
+			chunk := pending[first:min(first+maxBatchSize, len(pending))]
+			chunkRequests := make([]batchRequest, len(chunk))
+			for j, i := range chunk {
+				chunkRequests[j] = requests[i]
+			}
+			chunkResults, err := sendBatch(client, chunkRequests)
+			for j, i := range chunk {
+				if err != nil {
+					results[i] = batchResult{Err: err}
+				} else {
+					results[i] = chunkResults[j]
+				}
+			}
+		}
+
+		var failed []int
+		var delay time.Duration
+		for _, i := range pending {
+			if err := results[i].Err; err != nil && retryable(err) {
+				failed = append(failed, i)
+				delay = max(delay, retryAfter(err))
+			}
+		}
+		if len(failed) == 0 || attempt >= apiRetry.MaxRetries {
+			break
+		}
+		delay = max(delay, apiRetry.backoff(attempt))
+		if time.Since(start)+delay > apiRetry.MaxElapsed {
+			break
+		}
+		debugf("      ⏳ %d of %d batched calls failed, retrying them in %s\n", len(failed), len(pending), delay.Round(time.Millisecond))
+		time.Sleep(delay)
+		pending = failed
+	}
+	return results
+}
+
+// sendBatch sends one multipart batch request. The error is set when the
+// batch as a whole failed; errors of single calls are in their results.
+func sendBatch(client *http.Client, requests []batchRequest) ([]batchResult, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for i, request := range requests {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<item%d>", i))
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "%s %s HTTP/1.1\r\n", request.Method, request.Path)
+		if request.Event == nil {
+			fmt.Fprint(part, "\r\n")
+			continue
+		}
+		data, err := json.Marshal(request.Event)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "Content-Type: application/json\r\nContent-Length: %d\r\n\r\n", len(data))
+		part.Write(data)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	// Google counts every call of a batch against the quota
+	req, err := http.NewRequestWithContext(withRequestWeight(context.Background(), len(requests)), "POST", batchEndpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	results := make([]batchResult, len(requests))
+	for i := range results {
+		results[i].Err = errNoBatchResponse
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for n := 0; ; n++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading batch response: %w", err)
+		}
+		// Responses carry the Content-ID of their request, prefixed with "response-"
+		i := n
+		if id := part.Header.Get("Content-ID"); id != "" {
+			fmt.Sscanf(strings.Trim(id, "<>"), "response-item%d", &i)
+		}
+		if i < 0 || i >= len(results) {
+			continue
+		}
+		results[i] = readBatchPart(part)
+	}
+	return results, nil
+}
+
+func readBatchPart(part io.Reader) batchResult {
+	resp, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		return batchResult{Err: fmt.Errorf("error reading batch response: %w", err)}
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return batchResult{Err: err}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return batchResult{Err: fmt.Errorf("error reading batch response: %w", err)}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return batchResult{}
+	}
+	var event calendar.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return batchResult{Err: fmt.Errorf("error decoding batch response: %w", err)}
+	}
+	return batchResult{Event: &event}
+}
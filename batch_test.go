@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// batchCall is one call read from a batch request by the test server.
+type batchCall struct {
+	ContentID string
+	Method    string
+	Path      string
+}
+
+// batchReply is the test server's answer to one call, or to none when
+// ContentID is empty.
+type batchReply struct {
+	ContentID string
+	Status    int
+	Event     *calendar.Event
+}
+
+// newBatchServer starts a server answering batch requests with respond and
+// a client sending batchEndpoint requests to it.
+func newBatchServer(t *testing.T, respond func(calls []batchCall) []batchReply) *http.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("batch request content type: %v", err)
+			return
+		}
+		var calls []batchCall
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				t.Errorf("batch call: %v", err)
+				return
+			}
+			calls = append(calls, batchCall{ContentID: part.Header.Get("Content-ID"), Method: req.Method, Path: req.URL.Path})
+		}
+
+		writer := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+		for _, reply := range respond(calls) {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "application/http")
+			header.Set("Content-ID", "<response-"+strings.Trim(reply.ContentID, "<>")+">")
+			part, _ := writer.CreatePart(header)
+			body := []byte(`{"error": {"code": ` + fmt.Sprint(reply.Status) + `, "message": "failed"}}`)
+			if reply.Status == http.StatusOK {
+				body, _ = json.Marshal(reply.Event)
+			}
+			fmt.Fprintf(part, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
+				reply.Status, http.StatusText(reply.Status), len(body), body)
+		}
+		writer.Close()
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	return &http.Client{Transport: rewriteTransport{target}}
+}
+
+// rewriteTransport sends every request to target.
+type rewriteTransport struct{ target *url.URL }
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSendBatchMapsContentIDs(t *testing.T) {
+	client := newBatchServer(t, func(calls []batchCall) []batchReply {
+		// Answer out of order and leave the last call unanswered
+		var replies []batchReply
+		for i := len(calls) - 2; i >= 0; i-- {
+			replies = append(replies, batchReply{ContentID: calls[i].ContentID, Status: http.StatusOK,
+				Event: &calendar.Event{Id: strings.TrimPrefix(calls[i].Path, "/calendar/v3/calendars/cal/events/")}})
+		}
+		return replies
+	})
+
+	requests := []batchRequest{
+		eventRequest("GET", "cal", "a", nil),
+		eventRequest("GET", "cal", "b", nil),
+		eventRequest("GET", "cal", "c", nil),
+	}
+	results, err := sendBatch(client, requests)
+	if err != nil {
+		t.Fatalf("sendBatch: %v", err)
+	}
+	for i, want := range []string{"a", "b"} {
+		if results[i].Err != nil || results[i].Event == nil || results[i].Event.Id != want {
+			t.Errorf("result %d = %+v, want event %s", i, results[i], want)
+		}
+	}
+	if !errors.Is(results[2].Err, errNoBatchResponse) {
+		t.Errorf("result 2 error = %v, want errNoBatchResponse", results[2].Err)
+	}
+}
+
+func TestRunBatchRetriesFailedCalls(t *testing.T) {
+	saved := apiRetry
+	apiRetry = retryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxElapsed: time.Second}
+	t.Cleanup(func() { apiRetry = saved })
+
+	var mu sync.Mutex
+	var batches [][]string
+	client := newBatchServer(t, func(calls []batchCall) []batchReply {
+		mu.Lock()
+		defer mu.Unlock()
+		var paths []string
+		var replies []batchReply
+		for _, call := range calls {
+			paths = append(paths, call.Method+" "+call.Path)
+			status := http.StatusOK
+			switch {
+			case strings.HasSuffix(call.Path, "/flaky") && len(batches) == 0:
+				status = http.StatusServiceUnavailable
+			case strings.HasSuffix(call.Path, "/forbidden"):
+				status = http.StatusForbidden
+			}
+			replies = append(replies, batchReply{ContentID: call.ContentID, Status: status, Event: &calendar.Event{}})
+		}
+		batches = append(batches, paths)
+		return replies
+	})
+
+	requests := []batchRequest{
+		eventRequest("DELETE", "cal", "ok", nil),
+		eventRequest("DELETE", "cal", "flaky", nil),
+		eventRequest("DELETE", "cal", "forbidden", nil),
+	}
+	results := runBatch(client, requests)
+
+	if len(batches) != 2 {
+		t.Fatalf("sent %d batches, want 2: %v", len(batches), batches)
+	}
+	if got := batches[1]; len(got) != 1 || got[0] != "DELETE /calendar/v3/calendars/cal/events/flaky" {
+		t.Errorf("retried %v, want only the flaky call", got)
+	}
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Errorf("results = %+v, want the first two to succeed", results)
+	}
+	var googleErr *googleapi.Error
+	if !errors.As(results[2].Err, &googleErr) || googleErr.Code != http.StatusForbidden {
+		t.Errorf("result 2 error = %v, want 403", results[2].Err)
+	}
+}
+
+func TestSendBatchChargesEveryCall(t *testing.T) {
+	client := newBatchServer(t, func(calls []batchCall) []batchReply { return nil })
+	limiter := newRateLimiter(1000, 10)
+	client.Transport = &limitingTransport{base: client.Transport, limiter: limiter}
+
+	requests := make([]batchRequest, 30)
+	for i := range requests {
+		requests[i] = eventRequest("GET", "cal", fmt.Sprint(i), nil)
+	}
+	currentRun = &runStats{}
+	t.Cleanup(func() { currentRun = nil })
+	client.Transport = &countingTransport{base: client.Transport}
+	if _, err := sendBatch(client, requests); err != nil {
+		t.Fatalf("sendBatch: %v", err)
+	}
+	if currentRun.APICalls != 30 {
+		t.Errorf("counted %d API calls, want 30", currentRun.APICalls)
+	}
+	// 30 calls out of a bucket of 10 leave it 20 tokens short, less what
+	// came back while waiting
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.tokens > -15 {
+		t.Errorf("limiter has %.1f tokens left, want about -20", limiter.tokens)
+	}
+}
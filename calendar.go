@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+)
+
+// setCalendarEnabled pauses or resumes a calendar. A paused calendar is
+// skipped by sync both as a source and as a sink, but keeps its mapping.
+func setCalendarEnabled(accountName, calendarID string, enabled, removeBlockers, syncNow bool) {
+	if accountName == "" || calendarID == "" {
+		log.Fatalf("Usage: gcalsync calendar pause|resume --account name --calendar id")
+	}
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		log.Fatalf("Error reading config file: %v", err)
+	}
+
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	result, err := db.Exec("UPDATE calendars SET enabled = ? WHERE account_name = ? AND calendar_id = ?", enabled, accountName, calendarID)
+	if err != nil {
+		log.Fatalf("❌ Error updating calendar in database: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		log.Fatalf("❌ Calendar %s is not synced for account %s", calendarID, accountName)
+	}
+
+	if !enabled {
+		infof("⏸️ Calendar %s paused\n", calendarID)
+		if removeBlockers {
+			infof("🗑 Deleting blockers of calendar %s...\n", calendarID)
+			run := startRun(db, "pause")
+			deleteCalendarBlockers(db, config, calendarID)
+			run.finish(db)
+		}
+		return
+	}
+
+	infof("▶️ Calendar %s resumed\n", calendarID)
+	if syncNow {
+		db.Close()
+		syncCalendars(false)
+	}
+}
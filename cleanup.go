@@ -2,68 +2,109 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"strings"
 
 	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
 )
 
 func cleanupCalendars() {
-	config, err := readConfig(".gcalsync.toml")
+	config, err := readConfig(configFile)
 	if err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
 
-	db, err := openDB(".gcalsync.db")
+	db, err := openDB(dbFile)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
 
 	calendars := getCalendarsFromDB(db)
+	roles := getCalendarRolesFromDB(db)
 
 	ctx := context.Background()
+	run := startRun(db, "cleanup")
+
+	services := newServiceCache(ctx, db, config)
 
 	for accountName, calendarIDs := range calendars {
-		client := getClient(ctx, oauthConfig, db, accountName, config)
-		calendarService, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+		calendarService, err := services.get(accountName)
 		if err != nil {
-			log.Fatalf("Error creating calendar client: %v", err)
+			run.fail(err, "Skipping calendars of account %s", accountName)
+			continue
 		}
 
 		for _, calendarID := range calendarIDs {
-			fmt.Printf("🧹 Cleaning up calendar: %s\n", calendarID)
-			cleanupCalendar(calendarService, calendarID)
-			db.Exec("DELETE FROM blocker_events WHERE calendar_id = ?", calendarID)
+			// Source-only calendars never receive blockers
+			if !isSink(roles[calendarID]) {
+				continue
+			}
+			infof("🧹 Cleaning up calendar: %s\n", calendarID)
+			run.CalendarsProcessed++
+			failed, err := cleanupCalendar(db, calendarService, accountName, calendarID)
+			if err != nil {
+				run.fail(err, "Error cleaning up calendar %s", calendarID)
+				continue
+			}
+			if failed > 0 {
+				// Forgetting them would leave blockers nothing deletes anymore
+				log.Printf("⚠️ %d blockers of calendar %s couldn't be deleted, keeping them in the database\n", failed, calendarID)
+				continue
+			}
+			if _, err := db.Exec("DELETE FROM blocker_events WHERE calendar_id = ?", calendarID); err != nil {
+				run.fail(err, "Error deleting blocker events of calendar %s from database", calendarID)
+			}
 		}
 	}
 
-	fmt.Println("Calendars desynced successfully")
+	run.finish(db)
+	infof("Calendars desynced successfully\n")
 }
 
-func cleanupCalendar(calendarService *calendar.Service, calendarID string) {
-	// ctx := context.Background()
+// cleanupEventFields are the fields cleanup needs to find O_o events, plus
+// the ones undo needs to recreate them.
+const cleanupEventFields = "nextPageToken,items(id,summary,description,location,start,end,attendees,visibility,transparency,colorId,reminders)"
+
+// cleanupCalendar deletes the O_o events of a calendar. Events that can't be
+// deleted are reported and skipped, and counted in the number returned; an
+// error is returned only when the calendar can't be read.
+func cleanupCalendar(db *sql.DB, calendarService *calendar.Service, accountName, calendarID string) (int, error) {
 	pageToken := ""
+	failed := 0
 
 	for {
-		events, err := calendarService.Events.List(calendarID).
+		events, err := retryCall(calendarService.Events.List(calendarID).
 			PageToken(pageToken).
 			SingleEvents(true).
 			OrderBy("startTime").
-			Do()
+			MaxResults(maxEventsPerPage).
+			Fields(cleanupEventFields).
+			Do)
 		if err != nil {
-			log.Fatalf("Error retrieving events: %v", err)
+			return failed, fmt.Errorf("error retrieving events: %w", err)
 		}
 
+		currentRun.EventsScanned += len(events.Items)
 		for _, event := range events.Items {
 			if strings.Contains(event.Summary, "O_o") {
-				err := calendarService.Events.Delete(calendarID, event.Id).Do()
-				fmt.Printf("Deleted event %s from calendar %s\n", event.Summary, calendarID)
+				err := retryDo(calendarService.Events.Delete(calendarID, event.Id).Do)
 				if err != nil {
-					log.Fatalf("Error deleting blocker event: %v", err)
+					currentRun.fail(err, "Error deleting event %s from calendar %s", event.Summary, calendarID)
+					failed++
+					continue
 				}
+				infof("Deleted event %s from calendar %s\n", event.Summary, calendarID)
+				currentRun.BlockersDeleted++
+				recordAudit(db, auditEntry{
+					AccountName: accountName,
+					CalendarID:  calendarID,
+					EventID:     event.Id,
+					Action:      auditDelete,
+					Before:      event,
+				})
 			}
 		}
 
@@ -72,4 +113,5 @@ func cleanupCalendar(calendarService *calendar.Service, calendarID string) {
 			break
 		}
 	}
+	return failed, nil
 }
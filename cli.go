@@ -0,0 +1,310 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Global options, settable before or after the command name.
+var (
+	configFile   = ".gcalsync.toml"
+	dbFile       = ".gcalsync.db"
+	outputFormat = "text"
+	verbosity    = verbosityInfo
+)
+
+// Verbosity levels, matching verbosity in the config file
+const (
+	verbosityQuiet = 1
+	verbosityInfo  = 2
+	verbosityDebug = 3
+)
+
+type command struct {
+	name       string
+	args       string // synopsis of positional arguments and flags
+	summary    string
+	needsOAuth bool // whether the command talks to Google and needs a valid config
+	standalone bool // runs before any config or database exists
+	// setup registers the command flags and returns the function running it
+	setup func(flags *flag.FlagSet) func(args []string)
+}
+
+func noFlags(run func()) func(*flag.FlagSet) func([]string) {
+	return func(*flag.FlagSet) func([]string) {
+		return func([]string) { run() }
+	}
+}
+
+var commands = []*command{
+	{
+		name:       "init",
+		args:       "--credentials credentials.json [--force]",
+		summary:    "Create the config file from Google's credentials.json and initialize the database",
+		standalone: true,
+		setup: func(flags *flag.FlagSet) func([]string) {
+			credentials := flags.String("credentials", "", "path to credentials.json downloaded from Google Cloud Console")
+			force := flags.Bool("force", false, "overwrite an existing config file")
+			return func([]string) { initConfig(*credentials, *force) }
+		},
+	},
+	{
+		name:       "add",
+		args:       "[--account name] [--calendar id]... [--role both|source|sink] [--discover]",
+		summary:    "Add calendars to sync, asking for anything not given as an option",
+		needsOAuth: true,
+		setup: func(flags *flag.FlagSet) func([]string) {
+			account := flags.String("account", "", "account name")
+			var calendarIDs stringList
+			flags.Var(&calendarIDs, "calendar", "calendar ID, may be repeated")
+			role := flags.String("role", "", "calendar role: both, source or sink")
+			discover := flags.Bool("discover", false, "pick calendars from the account's calendar list")
+			return func([]string) { addCalendar(*account, calendarIDs, *role, *discover) }
+		},
+	},
+	{
+		name:       "remove",
+		args:       "--account name --calendar id [--dry-run]",
+		summary:    "Stop syncing a calendar, deleting the blockers it sent and received",
+		needsOAuth: true,
+		setup: func(flags *flag.FlagSet) func([]string) {
+			account := flags.String("account", "", "account name")
+			calendarID := flags.String("calendar", "", "calendar ID")
+			dryRun := flags.Bool("dry-run", false, "only show what would be deleted")
+			return func([]string) { removeCalendar(*account, *calendarID, *dryRun) }
+		},
+	},
+	{
+		name:       "calendar",
+		args:       "pause|resume --account name --calendar id [--remove-blockers] [--sync]",
+		summary:    "Pause or resume syncing a calendar without removing it",
+		needsOAuth: true,
+		setup: func(flags *flag.FlagSet) func([]string) {
+			account := flags.String("account", "", "account name")
+			calendarID := flags.String("calendar", "", "calendar ID")
+			removeBlockers := flags.Bool("remove-blockers", false, "pause: delete the blockers the calendar sent and received")
+			syncNow := flags.Bool("sync", false, "resume: sync right away to recreate blockers")
+			return func(args []string) {
+				if len(args) == 0 {
+					log.Fatalf("Usage: gcalsync calendar pause|resume --account name --calendar id")
+				}
+				// Options may follow the subcommand
+				flags.Parse(args[1:])
+				switch args[0] {
+				case "pause":
+					setCalendarEnabled(*account, *calendarID, false, *removeBlockers, false)
+				case "resume":
+					setCalendarEnabled(*account, *calendarID, true, false, *syncNow)
+				default:
+					log.Fatalf("Unknown calendar command: %s", args[0])
+				}
+			}
+		},
+	},
+	{
+		name:       "sync",
+		args:       "[--force]",
+		summary:    "Create, update and delete blocker events in all calendars",
+		needsOAuth: true,
+		setup: func(flags *flag.FlagSet) func([]string) {
+			force := flags.Bool("force", false, "apply the changes even if they exceed the safety limits")
+			return func([]string) { syncCalendars(*force) }
+		},
+	},
+	{
+		name:       "desync",
+		summary:    "Delete every blocker event gcalsync created",
+		needsOAuth: true,
+		setup:      noFlags(desyncCalendars),
+	},
+	{
+		name:       "undo",
+		args:       "[run-id]",
+		summary:    "Reverse the blocker changes of a run, by default the last sync or desync",
+		needsOAuth: true,
+		setup: func(flags *flag.FlagSet) func([]string) {
+			return func(args []string) {
+				runID := ""
+				if len(args) > 0 {
+					runID = args[0]
+				}
+				undoRun(runID)
+			}
+		},
+	},
+	{
+		name:       "cleanup",
+		summary:    "Delete every O_o event from all calendars, whether gcalsync knows it or not",
+		needsOAuth: true,
+		setup:      noFlags(cleanupCalendars),
+	},
+	{
+		name:    "list",
+		summary: "List synced calendars with their role, state and blocker counts",
+		setup:   noFlags(listCalendars),
+	},
+	{
+		name:    "history",
+		args:    "[run-id] [--limit n]",
+		summary: "Show recent sync, desync and cleanup runs, or the details of one run",
+		setup: func(flags *flag.FlagSet) func([]string) {
+			limit := flags.Int("limit", 20, "number of runs to show")
+			return func(args []string) {
+				runID := ""
+				if len(args) > 0 {
+					runID = args[0]
+					flags.Parse(args[1:])
+				}
+				showHistory(runID, *limit)
+			}
+		},
+	},
+	{
+		name:    "audit",
+		args:    "[--calendar id] [--event id] [--run id] [--since time] [--until time] [--limit n]",
+		summary: "Show the changes gcalsync made to calendars",
+		setup: func(flags *flag.FlagSet) func([]string) {
+			var filter auditFilter
+			flags.StringVar(&filter.CalendarID, "calendar", "", "only changes to or originating from this calendar")
+			flags.StringVar(&filter.EventID, "event", "", "only changes to this blocker or originating from this event")
+			flags.Int64Var(&filter.RunID, "run", 0, "only changes made by this run")
+			since := flags.String("since", "", "only changes at or after this time (YYYY-MM-DD or RFC 3339)")
+			until := flags.String("until", "", "only changes before this time (YYYY-MM-DD or RFC 3339)")
+			flags.IntVar(&filter.Limit, "limit", 100, "number of entries to show, 0 for all")
+			return func([]string) {
+				filter.Since = parseAuditTime(*since)
+				filter.Until = parseAuditTime(*until)
+				showAudit(filter)
+			}
+		},
+	},
+	{
+		name:    "tombstones",
+		args:    "[clear] [--calendar id] [--event id]",
+		summary: "List blockers deleted by hand that sync won't recreate, or clear them",
+		setup: func(flags *flag.FlagSet) func([]string) {
+			calendarID := flags.String("calendar", "", "only blockers in or originating from this calendar")
+			eventID := flags.String("event", "", "only blockers of this origin event")
+			return func(args []string) {
+				if len(args) == 0 {
+					listTombstones(*calendarID, *eventID)
+					return
+				}
+				// Options may follow the subcommand
+				flags.Parse(args[1:])
+				if args[0] != "clear" {
+					log.Fatalf("Unknown tombstones command: %s", args[0])
+				}
+				clearTombstones(*calendarID, *eventID)
+			}
+		},
+	},
+	{
+		name:    "status",
+		summary: "Summarize accounts: calendars, blockers, token expiry and last sync",
+		setup:   noFlags(showStatus),
+	},
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func addGlobalFlags(flags *flag.FlagSet) {
+	flags.StringVar(&configFile, "config", configFile, "path to the config file")
+	flags.StringVar(&dbFile, "db", dbFile, "path to the database file")
+	flags.StringVar(&outputFormat, "output", outputFormat, "output format: text or json")
+	flags.BoolFunc("verbose", "print details of every event (-v)", setVerbosity(verbosityDebug))
+	flags.BoolFunc("v", "shorthand for --verbose", setVerbosity(verbosityDebug))
+	flags.BoolFunc("quiet", "print errors only (-q)", setVerbosity(verbosityQuiet))
+	flags.BoolFunc("q", "shorthand for --quiet", setVerbosity(verbosityQuiet))
+}
+
+// verbositySet tells whether --verbose or --quiet was given, in which case the
+// config file setting is ignored.
+var verbositySet bool
+
+func setVerbosity(level int) func(string) error {
+	return func(string) error {
+		verbosity = level
+		verbositySet = true
+		return nil
+	}
+}
+
+func newFlagSet(cmd *command) *flag.FlagSet {
+	flags := flag.NewFlagSet("gcalsync "+cmd.name, flag.ExitOnError)
+	flags.Usage = func() { commandUsage(cmd) }
+	addGlobalFlags(flags)
+	return flags
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gcalsync [global options] <command> [options]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", "help", "Show help for a command")
+	fmt.Fprintln(os.Stderr, "\nGlobal options:")
+	globals := flag.NewFlagSet("gcalsync", flag.ContinueOnError)
+	addGlobalFlags(globals)
+	globals.SetOutput(os.Stderr)
+	globals.PrintDefaults()
+}
+
+func commandUsage(cmd *command) {
+	fmt.Fprintf(os.Stderr, "Usage: gcalsync %s %s\n\n%s\n\nOptions:\n", cmd.name, cmd.args, cmd.summary)
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmd.setup(flags)
+	addGlobalFlags(flags)
+	flags.SetOutput(os.Stderr)
+	flags.PrintDefaults()
+}
+
+func help(args []string) {
+	if len(args) == 0 {
+		usage()
+		return
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		os.Exit(1)
+	}
+	commandUsage(cmd)
+}
+
+// infof prints progress messages unless --quiet was given.
+func infof(format string, args ...any) {
+	if verbosity >= verbosityInfo {
+		fmt.Printf(format, args...)
+	}
+}
+
+// debugf prints per-event details, only with --verbose.
+func debugf(format string, args ...any) {
+	if verbosity >= verbosityDebug {
+		fmt.Printf(format, args...)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -32,20 +34,44 @@ type GeneralConfig struct {
 	EventVisibility  string `toml:"block_event_visibility"`
 	AuthorizedPorts  []int  `toml:"authorized_ports"`
 	Verbosity        int    `toml:"verbosity"`
+	IgnoreBirthdays  bool   `toml:"ignore_birthdays"`
+	EditedBlockers   string `toml:"edited_blockers,omitempty"` // keep (default) or overwrite
+
+	// Retrying Calendar API calls, see retryPolicy
+	MaxRetries      int           `toml:"max_retries,omitzero"`
+	RetryBaseDelay  time.Duration `toml:"retry_base_delay,omitzero"`
+	RetryMaxDelay   time.Duration `toml:"retry_max_delay,omitzero"`
+	RetryMaxElapsed time.Duration `toml:"retry_max_elapsed,omitzero"`
+
+	// Client-side request rate per account, see rateLimiter
+	RequestsPerSecond float64 `toml:"requests_per_second,omitzero"`
+	RequestBurst      int     `toml:"request_burst,omitzero"`
 }
 
 type Config struct {
-	General GeneralConfig `toml:"general"`
-	Google  GoogleConfig  `toml:"google"`
+	General  GeneralConfig            `toml:"general"`
+	Google   GoogleConfig             `toml:"google"`
+	Secrets  SecretsConfig            `toml:"secrets,omitempty"`
+	Accounts map[string]AccountConfig `toml:"accounts,omitempty"`
+	Safety   SafetyConfig             `toml:"safety,omitempty"`
 }
 
 var oauthConfig *oauth2.Config
 var configDir string
 
 func initOAuthConfig(config *Config) {
+	// A client secret in the config file wins, otherwise ask the secret store
+	clientSecret := config.Google.ClientSecret
+	if clientSecret == "" {
+		var err error
+		clientSecret, err = secretStore.Get(clientSecretKey)
+		if err != nil && !errors.Is(err, errSecretNotFound) {
+			log.Fatalf("Error reading client secret from secret store: %v", err)
+		}
+	}
 	oauthConfig = &oauth2.Config{
 		ClientID:     config.Google.ClientID,
-		ClientSecret: config.Google.ClientSecret,
+		ClientSecret: clientSecret,
 		Endpoint:     google.Endpoint,
 		Scopes:       []string{calendar.CalendarScope},
 		// RedirectURL will be set dynamically in getTokenFromWeb
@@ -72,6 +98,9 @@ func readConfig(filename string) (*Config, error) {
 	if err := toml.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+	if err := checkAccounts(&config); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
@@ -136,6 +165,9 @@ func upadteConfigFormatIfNeeded(data []byte, configDir, filename string) error {
 }
 
 func openDB(filename string) (*sql.DB, error) {
+	if filepath.IsAbs(filename) {
+		return sql.Open("sqlite3", filename)
+	}
 	// Try first the same dir, where the config file was found
 	db, err := sql.Open("sqlite3", configDir+filename)
 	if err != nil {
@@ -150,7 +182,11 @@ func openDB(filename string) (*sql.DB, error) {
 
 func getTokenFromWeb(config *oauth2.Config, cfg *Config) *oauth2.Token {
 	// Start local server
-	listener, err := findAvailablePort(cfg.General.AuthorizedPorts)
+	ports := cfg.General.AuthorizedPorts
+	if len(ports) == 0 {
+		ports = defaultAuthorizedPorts
+	}
+	listener, err := findAvailablePort(ports)
 	if err != nil {
 		log.Fatalf("Unable to start listener: %v", err)
 	}
@@ -176,7 +212,8 @@ func getTokenFromWeb(config *oauth2.Config, cfg *Config) *oauth2.Token {
 
 	go server.Serve(listener)
 
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	// Ask for incremental authorization so previously granted scopes are kept
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
 	fmt.Printf("Please visit this URL to authorize the application: \n%v\n", authURL)
 
 	// Open browser automatically
@@ -202,36 +239,89 @@ func getTokenFromWeb(config *oauth2.Config, cfg *Config) *oauth2.Token {
 	return tok
 }
 
-func saveToken(db *sql.DB, accountName string, token *oauth2.Token) error {
+// getTokenFromDevice runs the OAuth 2.0 device authorization grant: the user
+// enters a short code on another device while we poll the token endpoint.
+func getTokenFromDevice(config *oauth2.Config) *oauth2.Token {
+	ctx := context.Background()
+	response, err := config.DeviceAuth(ctx)
+	if err != nil {
+		log.Fatalf("Unable to start device authorization: %v", err)
+	}
+
+	fmt.Printf("Please visit %s and enter the code: %s\n", response.VerificationURI, response.UserCode)
+	if !response.Expiry.IsZero() {
+		fmt.Printf("The code expires at %s\n", response.Expiry.Local().Format("15:04:05"))
+	}
+
+	// DeviceAccessToken keeps polling on authorization_pending and backs off on slow_down
+	tok, err := config.DeviceAccessToken(ctx, response)
+	if err != nil {
+		log.Fatalf("Unable to retrieve token: %v", err)
+	}
+	return tok
+}
+
+func saveToken(accountName string, token *oauth2.Token) error {
 	tokenJSON, err := json.Marshal(token)
 	if err != nil {
 		return err
 	}
+	return secretStore.Set(tokenKey(accountName), string(tokenJSON))
+}
 
-	_, err = db.Exec("INSERT OR REPLACE INTO tokens (account_name, token) VALUES (?, ?)", accountName, tokenJSON)
-	return err
+// getClient returns an HTTP client for accountName holding just the scopes its
+// calendars need according to their roles.
+func getClient(ctx context.Context, db *sql.DB, accountName string, cfg *Config) (*http.Client, error) {
+	return getClientWithScopes(ctx, accountName, cfg, accountScopes(accountRolesFromDB(db, accountName)))
 }
 
-func getClient(ctx context.Context, config *oauth2.Config, db *sql.DB, accountName string, cfg *Config) *http.Client {
-	var tokenJSON []byte
-	err := db.QueryRow("SELECT token FROM tokens WHERE account_name = ?", accountName).Scan(&tokenJSON)
+func getClientWithScopes(ctx context.Context, accountName string, cfg *Config, scopes []string) (*http.Client, error) {
+	client, err := newClient(ctx, accountName, cfg, scopes)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Printf("  ❗️ No token found for account %s. Obtaining a new token.\n", accountName)
-			token := getTokenFromWeb(config, cfg)
-			saveToken(db, accountName, token)
-			return config.Client(ctx, token)
+		return nil, err
+	}
+	return instrumentClient(client, accountLimiter(cfg, accountName)), nil
+}
+
+// newClient returns an HTTP client authorized for accountName, asking the
+// user to log in when there is no usable token. Errors reading the stored
+// token or refreshing it are returned.
+func newClient(ctx context.Context, accountName string, cfg *Config, scopes []string) (*http.Client, error) {
+	// Scopes set explicitly in the account section always win. Service
+	// accounts are delegated the full calendar scope, and Google rejects
+	// requests for scopes that weren't delegated, narrower ones included
+	if configured := cfg.account(accountName).Scopes; len(configured) > 0 {
+		scopes = configured
+	} else if cfg.isServiceAccount(accountName) {
+		scopes = []string{calendar.CalendarScope}
+	}
+
+	if cfg.isServiceAccount(accountName) {
+		client, err := getServiceAccountClient(ctx, accountName, cfg.account(accountName), scopes)
+		if err != nil {
+			return nil, fmt.Errorf("error creating service account client: %w", err)
 		}
-		log.Fatalf("Error retrieving token from database: %v", err)
+		return client, nil
 	}
 
-	var token oauth2.Token
-	err = json.Unmarshal(tokenJSON, &token)
+	config := oauthConfigFor(cfg, accountName)
+	config.Scopes = scopes
+
+	token, err := loadToken(accountName)
 	if err != nil {
-		log.Fatalf("Error unmarshaling token: %v", err)
+		if errors.Is(err, errSecretNotFound) {
+			fmt.Printf("  ❗️ No token found for account %s. Obtaining a new token.\n", accountName)
+			return config.Client(ctx, authorizeAccount(config, cfg, accountName)), nil
+		}
+		return nil, fmt.Errorf("error retrieving token from secret store: %w", err)
+	}
+
+	if missing := missingScopes(loadGrantedScopes(accountName), scopes); len(missing) > 0 {
+		fmt.Printf("  ❗️ Token for account %s lacks scopes %s. Requesting additional authorization.\n", accountName, strings.Join(missing, " "))
+		return config.Client(ctx, authorizeAccount(config, cfg, accountName)), nil
 	}
 
-	tokenSource := config.TokenSource(ctx, &token)
+	tokenSource := config.TokenSource(ctx, token)
 	newToken, err := tokenSource.Token()
 	if err != nil {
 		if strings.Contains(err.Error(), "token expired") ||
@@ -240,67 +330,82 @@ func getClient(ctx context.Context, config *oauth2.Config, db *sql.DB, accountNa
 			strings.Contains(err.Error(), "oauth2: token expired and refresh token is not set") {
 			fmt.Printf("  ❗️ Token expired or revoked for account %s. Obtaining a new token.\n", accountName)
 			// Delete the existing invalid token
-			_, err := db.Exec("DELETE FROM tokens WHERE account_name = ?", accountName)
+			err := secretStore.Delete(tokenKey(accountName))
 			if err != nil {
 				log.Printf("Warning: Failed to delete invalid token: %v", err)
 			}
 			// Get a new token from the web
-			newToken = getTokenFromWeb(config, cfg)
-			saveToken(db, accountName, newToken)
-			return config.Client(ctx, newToken)
+			return config.Client(ctx, authorizeAccount(config, cfg, accountName)), nil
 		}
-		log.Fatalf("Error retrieving token from token source: %v", err)
+		return nil, fmt.Errorf("error retrieving token from token source: %w", err)
 	}
 
 	if newToken.AccessToken != token.AccessToken {
-		fmt.Printf("Token refreshed for account %s.\n", accountName)
-		saveToken(db, accountName, newToken)
+		infof("Token refreshed for account %s.\n", accountName)
+		saveToken(accountName, newToken)
 	}
 
 	// Check if the token is expired and refresh it if necessary
 	if token.Expiry.Before(time.Now()) {
 		fmt.Printf("  ❗️ Token expired for account %s. Refreshing token.\n", accountName)
-		newToken, err := config.TokenSource(ctx, &token).Token()
+		newToken, err := config.TokenSource(ctx, token).Token()
 		if err != nil {
-			log.Fatalf("Error refreshing token: %v", err)
+			return nil, fmt.Errorf("error refreshing token: %w", err)
 		}
-		saveToken(db, accountName, newToken)
-		return config.Client(ctx, newToken)
+		saveToken(accountName, newToken)
+		return config.Client(ctx, newToken), nil
 	}
 
-	return config.Client(ctx, &token)
+	return config.Client(ctx, token), nil
+}
+
+// authorizeAccount runs the browser flow for accountName and stores the
+// resulting token along with the scopes it was granted.
+func authorizeAccount(config *oauth2.Config, cfg *Config, accountName string) *oauth2.Token {
+	var token *oauth2.Token
+	if cfg.account(accountName).AuthFlow == authFlowDevice {
+		token = getTokenFromDevice(config)
+	} else {
+		token = getTokenFromWeb(config, cfg)
+	}
+	if err := saveToken(accountName, token); err != nil {
+		log.Printf("Warning: Failed to save token: %v", err)
+	}
+	if err := saveGrantedScopes(accountName, token, config.Scopes); err != nil {
+		log.Printf("Warning: Failed to save granted scopes: %v", err)
+	}
+	return token
 }
 
 // Check if the token has expired and refresh if necessary, return updated calendarService
-func tokenExpired(db *sql.DB, accountName string, calendarService *calendar.Service, ctx context.Context) *calendar.Service {
-	var tokenJSON []byte
-	err := db.QueryRow("SELECT token FROM tokens WHERE account_name = ?", accountName).Scan(&tokenJSON)
-	if err != nil {
-		log.Fatalf("Error retrieving token from database: %v", err)
+func tokenExpired(accountName string, calendarService *calendar.Service, ctx context.Context, cfg *Config) (*calendar.Service, error) {
+	// Service accounts mint their own short-lived tokens
+	if cfg.isServiceAccount(accountName) {
+		return calendarService, nil
 	}
 
-	var token oauth2.Token
-	err = json.Unmarshal(tokenJSON, &token)
+	token, err := loadToken(accountName)
 	if err != nil {
-		log.Fatalf("Error unmarshaling token: %v", err)
+		return nil, fmt.Errorf("error retrieving token from secret store: %w", err)
 	}
 
 	if token.Expiry.Before(time.Now()) {
 		fmt.Printf("  ❗️ Token expired for account %s. Refreshing token.\n", accountName)
-		newToken, err := oauthConfig.TokenSource(ctx, &token).Token()
+		config := oauthConfigFor(cfg, accountName)
+		newToken, err := config.TokenSource(ctx, token).Token()
 		if err != nil {
-			log.Fatalf("Error refreshing token: %v", err)
+			return nil, fmt.Errorf("error refreshing token: %w", err)
 		}
-		saveToken(db, accountName, newToken)
+		saveToken(accountName, newToken)
 
 		// Create new calendar service with updated token
-		calendarService, err = calendar.NewService(ctx, option.WithHTTPClient(oauthConfig.Client(ctx, newToken)))
+		calendarService, err = calendar.NewService(ctx, option.WithHTTPClient(instrumentClient(config.Client(ctx, newToken), accountLimiter(cfg, accountName))))
 		if err != nil {
-			log.Fatalf("Unable to create new calendar service: %v", err)
+			return nil, fmt.Errorf("unable to create new calendar service: %w", err)
 		}
 	}
 
-	return calendarService
+	return calendarService, nil
 }
 
 // Helper function to find an available port in a range
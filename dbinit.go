@@ -1,9 +1,12 @@
 package main
 
-import "log"
+import (
+	"log"
+	"time"
+)
 
 func dbInit() {
-	db, err := openDB(".gcalsync.db")
+	db, err := openDB(dbFile)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
@@ -101,4 +104,286 @@ func dbInit() {
 			log.Fatalf("Error updating db_version table: %v", err)
 		}
 	}
+
+	if dbVersion == 4 {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS secrets (
+			name TEXT PRIMARY KEY,
+			value TEXT
+		)`)
+		if err != nil {
+			log.Fatalf("Error creating secrets table: %v", err)
+		}
+
+		dbVersion = 5
+		_, err = db.Exec(`UPDATE db_version SET version = 5 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 5 {
+		_, err = db.Exec(`ALTER TABLE calendars ADD COLUMN role TEXT DEFAULT 'both'`)
+		if err != nil {
+			log.Fatalf("Error adding role column to calendars table: %v", err)
+		}
+
+		dbVersion = 6
+		_, err = db.Exec(`UPDATE db_version SET version = 6 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 6 {
+		_, err = db.Exec(`ALTER TABLE calendars ADD COLUMN enabled INTEGER DEFAULT 1`)
+		if err != nil {
+			log.Fatalf("Error adding enabled column to calendars table: %v", err)
+		}
+
+		dbVersion = 7
+		_, err = db.Exec(`UPDATE db_version SET version = 7 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 7 {
+		_, err = db.Exec(`ALTER TABLE calendars ADD COLUMN summary TEXT DEFAULT ''`)
+		if err != nil {
+			log.Fatalf("Error adding summary column to calendars table: %v", err)
+		}
+		_, err = db.Exec(`ALTER TABLE calendars ADD COLUMN last_synced TEXT DEFAULT ''`)
+		if err != nil {
+			log.Fatalf("Error adding last_synced column to calendars table: %v", err)
+		}
+
+		dbVersion = 8
+		_, err = db.Exec(`UPDATE db_version SET version = 8 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 8 {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sync_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			command TEXT,
+			started_at TEXT,
+			finished_at TEXT,
+			calendars_processed INTEGER DEFAULT 0,
+			events_scanned INTEGER DEFAULT 0,
+			blockers_created INTEGER DEFAULT 0,
+			blockers_updated INTEGER DEFAULT 0,
+			blockers_deleted INTEGER DEFAULT 0,
+			api_calls INTEGER DEFAULT 0,
+			errors INTEGER DEFAULT 0
+		)`)
+		if err != nil {
+			log.Fatalf("Error creating sync_runs table: %v", err)
+		}
+
+		dbVersion = 9
+		_, err = db.Exec(`UPDATE db_version SET version = 9 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 9 {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TEXT,
+			run_id INTEGER,
+			account_name TEXT,
+			calendar_id TEXT,
+			event_id TEXT,
+			origin_calendar_id TEXT,
+			origin_event_id TEXT,
+			action TEXT,
+			before TEXT,
+			after TEXT
+		)`)
+		if err != nil {
+			log.Fatalf("Error creating audit_log table: %v", err)
+		}
+
+		// The audit log is append-only
+		_, err = db.Exec(`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`)
+		if err != nil {
+			log.Fatalf("Error creating audit_log triggers: %v", err)
+		}
+		_, err = db.Exec(`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`)
+		if err != nil {
+			log.Fatalf("Error creating audit_log triggers: %v", err)
+		}
+
+		dbVersion = 10
+		_, err = db.Exec(`UPDATE db_version SET version = 10 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 10 {
+		_, err = db.Exec(`ALTER TABLE sync_runs ADD COLUMN undone_by INTEGER`)
+		if err != nil {
+			log.Fatalf("Error adding undone_by column to sync_runs table: %v", err)
+		}
+
+		dbVersion = 11
+		_, err = db.Exec(`UPDATE db_version SET version = 11 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 11 {
+		_, err = db.Exec(`ALTER TABLE sync_runs ADD COLUMN rate_limit_wait_ms INTEGER DEFAULT 0`)
+		if err != nil {
+			log.Fatalf("Error adding rate_limit_wait_ms column to sync_runs table: %v", err)
+		}
+
+		dbVersion = 12
+		_, err = db.Exec(`UPDATE db_version SET version = 12 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 12 {
+		_, err = db.Exec(`ALTER TABLE sync_runs ADD COLUMN bytes_transferred INTEGER DEFAULT 0`)
+		if err != nil {
+			log.Fatalf("Error adding bytes_transferred column to sync_runs table: %v", err)
+		}
+
+		dbVersion = 13
+		_, err = db.Exec(`UPDATE db_version SET version = 13 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 13 {
+		_, err = db.Exec(`ALTER TABLE blocker_events ADD COLUMN content_hash TEXT DEFAULT ''`)
+		if err != nil {
+			log.Fatalf("Error adding content_hash column to blocker_events table: %v", err)
+		}
+
+		dbVersion = 14
+		_, err = db.Exec(`UPDATE db_version SET version = 14 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 14 {
+		_, err = db.Exec(`ALTER TABLE blocker_events ADD COLUMN blocker_updated TEXT DEFAULT ''`)
+		if err != nil {
+			log.Fatalf("Error adding blocker_updated column to blocker_events table: %v", err)
+		}
+
+		dbVersion = 15
+		_, err = db.Exec(`UPDATE db_version SET version = 15 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 15 {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS tombstones (
+			calendar_id TEXT,
+			account_name TEXT,
+			event_id TEXT,
+			origin_calendar_id TEXT,
+			origin_event_id TEXT,
+			times_hash TEXT,
+			created_at TEXT,
+			PRIMARY KEY (calendar_id, origin_calendar_id, origin_event_id)
+		)`)
+		if err != nil {
+			log.Fatalf("Error creating tombstones table: %v", err)
+		}
+
+		dbVersion = 16
+		_, err = db.Exec(`UPDATE db_version SET version = 16 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 16 {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS intents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at TEXT,
+			run_id INTEGER,
+			action TEXT,
+			account_name TEXT,
+			calendar_id TEXT,
+			event_id TEXT,
+			origin_calendar_id TEXT,
+			origin_event_id TEXT,
+			event TEXT
+		)`)
+		if err != nil {
+			log.Fatalf("Error creating intents table: %v", err)
+		}
+
+		dbVersion = 17
+		_, err = db.Exec(`UPDATE db_version SET version = 17 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 17 {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS undone_changes (
+			audit_id INTEGER PRIMARY KEY,
+			run_id INTEGER,
+			event_id TEXT
+		)`)
+		if err != nil {
+			log.Fatalf("Error creating undone_changes table: %v", err)
+		}
+
+		dbVersion = 18
+		_, err = db.Exec(`UPDATE db_version SET version = 18 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
+
+	if dbVersion == 18 {
+		// Audit timestamps were stored with the local offset, which doesn't
+		// compare as strings; convert them to UTC
+		rows, err := db.Query(`SELECT id, timestamp FROM audit_log`)
+		if err != nil {
+			log.Fatalf("Error retrieving audit log timestamps: %v", err)
+		}
+		timestamps := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var timestamp string
+			if err := rows.Scan(&id, &timestamp); err != nil {
+				log.Fatalf("Error scanning audit log row: %v", err)
+			}
+			if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+				timestamps[id] = t.UTC().Format(time.RFC3339)
+			}
+		}
+		rows.Close()
+		for id, timestamp := range timestamps {
+			_, err = db.Exec(`UPDATE audit_log SET timestamp = ? WHERE id = ?`, timestamp, id)
+			if err != nil {
+				log.Fatalf("Error converting audit log timestamps: %v", err)
+			}
+		}
+
+		dbVersion = 19
+		_, err = db.Exec(`UPDATE db_version SET version = 19 WHERE name = 'gcalsync'`)
+		if err != nil {
+			log.Fatalf("Error updating db_version table: %v", err)
+		}
+	}
 }
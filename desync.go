@@ -3,80 +3,107 @@ package main
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"log"
-
-	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/googleapi"
-	"google.golang.org/api/option"
 )
 
 func desyncCalendars() {
-	config, err := readConfig(".gcalsync.toml")
+	config, err := readConfig(configFile)
 	if err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
 
 	ctx := context.Background()
-	db, err := openDB(".gcalsync.db")
+	db, err := openDB(dbFile)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
 
-	fmt.Println("🚀 Starting calendar desynchronization...")
+	run := startRun(db, "desync")
+	infof("🚀 Starting calendar desynchronization...\n")
+	services := newServiceCache(ctx, db, config)
+	recoverIntents(db, services)
 
-	rows, err := db.Query("SELECT event_id, calendar_id, account_name FROM blocker_events")
+	rows, err := db.Query("SELECT event_id, calendar_id, account_name, coalesce(origin_calendar_id, ''), origin_event_id FROM blocker_events")
 	if err != nil {
 		log.Fatalf("❌ Error retrieving blocker events from database: %v", err)
 	}
-	defer rows.Close()
 
-	var eventIDCalendarIDPairs []struct {
-		EventID    string
-		CalendarID string
+	type desyncBlocker struct {
+		EventID          string
+		CalendarID       string
+		AccountName      string
+		OriginCalendarID string
+		OriginEventID    string
 	}
-
+	var blockers []desyncBlocker
 	for rows.Next() {
-		var eventID, calendarID, accountName string
-		if err := rows.Scan(&eventID, &calendarID, &accountName); err != nil {
+		var b desyncBlocker
+		if err := rows.Scan(&b.EventID, &b.CalendarID, &b.AccountName, &b.OriginCalendarID, &b.OriginEventID); err != nil {
 			log.Fatalf("❌ Error scanning blocker event row: %v", err)
 		}
+		blockers = append(blockers, b)
+	}
+	rows.Close()
 
-		eventIDCalendarIDPairs = append(eventIDCalendarIDPairs, struct {
-			EventID    string
-			CalendarID string
-		}{EventID: eventID, CalendarID: calendarID})
-
-		client := getClient(ctx, oauthConfig, db, accountName, config)
-		calendarService, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	// Each blocker is journaled, deleted, then forgotten together with
+	// closing its intent, so a crash never leaves rows without blockers
+	for _, b := range blockers {
+		eventID, calendarID, accountName := b.EventID, b.CalendarID, b.AccountName
+		calendarService, err := services.get(accountName)
 		if err != nil {
-			log.Fatalf("❌ Error creating calendar client: %v", err)
+			run.fail(err, "Error deleting blocker event %s in calendar %s", eventID, calendarID)
+			continue
 		}
 
-		err = calendarService.Events.Delete(calendarID, eventID).Do()
+		// Keep what the blocker looked like for the audit log
+		before, _ := retryCall(calendarService.Events.Get(calendarID, eventID).Do)
+
+		intentID := recordIntents(db, []intent{{Action: auditDelete, AccountName: accountName, CalendarID: calendarID, EventID: eventID,
+			OriginCalendarID: b.OriginCalendarID, OriginEventID: b.OriginEventID, Event: before}})[0]
+		err = retryDo(calendarService.Events.Delete(calendarID, eventID).Do)
+		deleted := err == nil
 		if err != nil {
-			if googleErr, ok := err.(*googleapi.Error); ok && googleErr.Code == 404 {
-				fmt.Printf("  ⚠️ Blocker event not found in calendar: %s\n", eventID)
-			} else {
-				log.Fatalf("❌ Error deleting blocker event: %v", err)
+			if !isGone(err) {
+				// Keep it in the database so that the next desync tries again
+				run.fail(err, "Error deleting blocker event %s in calendar %s", eventID, calendarID)
+				failIntent(db, intentID, err)
+				continue
 			}
-		} else {
-			fmt.Printf("  ✅ Blocker event deleted: %s\n", eventID)
+			infof("  ⚠️ Blocker event not found in calendar: %s\n", eventID)
 		}
-	}
 
-	// Delete blocker events from the database after the iteration
-	for _, pair := range eventIDCalendarIDPairs {
-		_, err := db.Exec("DELETE FROM blocker_events WHERE event_id = ? AND calendar_id = ?", pair.EventID, pair.CalendarID)
+		err = inTransaction(db, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM blocker_events WHERE event_id = ? AND calendar_id = ?", eventID, calendarID)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				recordAudit(tx, auditEntry{
+					AccountName:      accountName,
+					CalendarID:       calendarID,
+					EventID:          eventID,
+					OriginCalendarID: b.OriginCalendarID,
+					OriginEventID:    b.OriginEventID,
+					Action:           auditDelete,
+					Before:           before,
+				})
+			}
+			return completeIntent(tx, intentID)
+		})
 		if err != nil {
-			log.Fatalf("❌ Error deleting blocker event from database: %v", err)
-		} else {
-			fmt.Printf("  📥 Blocker event deleted from database: %s\n", pair.EventID)
+			run.fail(err, "Error deleting blocker event %s from database", eventID)
+			continue
+		}
+		debugf("  📥 Blocker event deleted from database: %s\n", eventID)
+		if deleted {
+			run.BlockersDeleted++
+			infof("  ✅ Blocker event deleted: %s\n", eventID)
 		}
 	}
 
-	fmt.Println("Calendars desynced successfully")
+	run.finish(db)
+	infof("Calendars desynced\n")
 }
 
 func getAccountNameByCalendarID(db *sql.DB, calendarID string) string {
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Error classes, used to tell what kind of trouble a run ran into
+const (
+	errClassAuth       = "auth"
+	errClassPermission = "permission"
+	errClassNotFound   = "not found"
+	errClassRateLimit  = "rate limit"
+	errClassTransient  = "transient"
+	errClassOther      = "other"
+)
+
+// exitRunErrors is the exit status of a run that went through but hit errors
+// on the way. Failures that stop a command altogether exit with 1.
+const exitRunErrors = 2
+
+// runFailed is set when a finished run had errors, main exits with
+// exitRunErrors then.
+var runFailed bool
+
+// runFailure is an error a run carried on after.
+type runFailure struct {
+	Class   string
+	Message string
+}
+
+// classifyError sorts an error from Google or the network into an error class.
+func classifyError(err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return errClassAuth
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		switch {
+		case googleErr.Code == 401:
+			return errClassAuth
+		case googleErr.Code == 429:
+			return errClassRateLimit
+		case googleErr.Code == 403:
+			for _, item := range googleErr.Errors {
+				if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+					return errClassRateLimit
+				}
+			}
+			return errClassPermission
+		case googleErr.Code == 404 || googleErr.Code == 410:
+			return errClassNotFound
+		case googleErr.Code >= 500:
+			return errClassTransient
+		}
+		return errClassOther
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errClassTransient
+	}
+	return errClassOther
+}
+
+// isGone tells whether a Google API error means the event no longer exists.
+func isGone(err error) bool {
+	var googleErr *googleapi.Error
+	return errors.As(err, &googleErr) && (googleErr.Code == 404 || googleErr.Code == 410)
+}
+
+// isConflict tells whether a Google API error means the event ID is taken.
+func isConflict(err error) bool {
+	var googleErr *googleapi.Error
+	return errors.As(err, &googleErr) && googleErr.Code == 409
+}
+
+// fail reports an error the run carries on after, and counts it.
+func (run *runStats) fail(err error, format string, args ...any) {
+	class := classifyError(err)
+	message := fmt.Sprintf(format, args...)
+	log.Printf("❌ %s (%s): %v\n", message, class, err)
+	run.Errors++
+	run.failures = append(run.failures, runFailure{Class: class, Message: message})
+}
+
+// printFailures sums up the errors of a run by class.
+func (run *runStats) printFailures() {
+	if run.Errors == 0 {
+		return
+	}
+	byClass := make(map[string][]string)
+	for _, failure := range run.failures {
+		byClass[failure.Class] = append(byClass[failure.Class], failure.Message)
+	}
+	classes := make([]string, 0, len(byClass))
+	for class := range byClass {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	log.Printf("⚠️ Run %d finished with %d errors:\n", run.ID, run.Errors)
+	for _, class := range classes {
+		log.Printf("  %s: %d\n", class, len(byClass[class]))
+		for _, message := range byClass[class] {
+			log.Printf("    - %s\n", message)
+		}
+	}
+	switch {
+	case len(byClass[errClassAuth]) > 0:
+		log.Printf("💡 Some accounts couldn't authenticate, the next run will ask them to log in again\n")
+	case len(byClass[errClassRateLimit]) > 0 || len(byClass[errClassTransient]) > 0:
+		log.Printf("💡 Some errors were temporary, running again should fix them\n")
+	}
+}
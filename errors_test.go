@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unauthorized", &googleapi.Error{Code: 401}, errClassAuth},
+		{"token refresh", &oauth2.RetrieveError{}, errClassAuth},
+		{"forbidden", &googleapi.Error{Code: 403}, errClassPermission},
+		{"rate limit reason", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, errClassRateLimit},
+		{"user rate limit reason", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, errClassRateLimit},
+		{"too many requests", &googleapi.Error{Code: 429}, errClassRateLimit},
+		{"not found", &googleapi.Error{Code: 404}, errClassNotFound},
+		{"gone", &googleapi.Error{Code: 410}, errClassNotFound},
+		{"server error", &googleapi.Error{Code: 503}, errClassTransient},
+		{"conflict", &googleapi.Error{Code: 409}, errClassOther},
+		{"wrapped", fmt.Errorf("error retrieving events: %w", &googleapi.Error{Code: 500}), errClassTransient},
+		{"network", &net.DNSError{Err: "timeout", IsTimeout: true}, errClassTransient},
+		{"other", errors.New("boom"), errClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// runStats collects what a single sync, desync or cleanup run did. It is
+// saved in the sync_runs table when the run starts and again when it ends,
+// so runs killed half-way show up without an end time.
+type runStats struct {
+	ID                 int64         `json:"id"`
+	Command            string        `json:"command"`
+	StartedAt          string        `json:"started_at"`
+	FinishedAt         string        `json:"finished_at"`
+	CalendarsProcessed int           `json:"calendars_processed"`
+	EventsScanned      int           `json:"events_scanned"`
+	BlockersCreated    int           `json:"blockers_created"`
+	BlockersUpdated    int           `json:"blockers_updated"`
+	BlockersDeleted    int           `json:"blockers_deleted"`
+	APICalls           int64         `json:"api_calls"`
+	BytesTransferred   int64         `json:"bytes_transferred"`
+	RateLimitWait      time.Duration `json:"rate_limit_wait"`
+	Errors             int           `json:"errors"`
+
+	failures []runFailure
+}
+
+// currentRun is the run in progress, if any. API calls made by clients from
+// getClient are counted against it.
+var currentRun *runStats
+
+func startRun(db *sql.DB, command string) *runStats {
+	run := &runStats{Command: command, StartedAt: time.Now().Format(time.RFC3339)}
+	result, err := db.Exec("INSERT INTO sync_runs (command, started_at) VALUES (?, ?)", run.Command, run.StartedAt)
+	if err != nil {
+		log.Fatalf("Error recording run: %v", err)
+	}
+	run.ID, _ = result.LastInsertId()
+	currentRun = run
+	return run
+}
+
+func (run *runStats) finish(db *sql.DB) {
+	run.FinishedAt = time.Now().Format(time.RFC3339)
+	_, err := db.Exec(`UPDATE sync_runs SET finished_at = ?, calendars_processed = ?, events_scanned = ?,
+		blockers_created = ?, blockers_updated = ?, blockers_deleted = ?, api_calls = ?, bytes_transferred = ?, rate_limit_wait_ms = ?, errors = ? WHERE id = ?`,
+		run.FinishedAt, run.CalendarsProcessed, run.EventsScanned, run.BlockersCreated, run.BlockersUpdated,
+		run.BlockersDeleted, atomic.LoadInt64(&run.APICalls), atomic.LoadInt64(&run.BytesTransferred),
+		run.rateLimitWait().Milliseconds(), run.Errors, run.ID)
+	if err != nil {
+		log.Printf("Error recording run statistics: %v\n", err)
+	}
+	currentRun = nil
+	infof("📊 Run %d: %d calendars, %d events scanned, %d blockers created, %d updated, %d deleted, %d API calls (%s, %s throttled), %d errors\n",
+		run.ID, run.CalendarsProcessed, run.EventsScanned, run.BlockersCreated, run.BlockersUpdated, run.BlockersDeleted,
+		atomic.LoadInt64(&run.APICalls), formatBytes(atomic.LoadInt64(&run.BytesTransferred)),
+		run.rateLimitWait().Round(time.Millisecond), run.Errors)
+	run.printFailures()
+	if run.Errors > 0 {
+		runFailed = true
+	}
+}
+
+func (run *runStats) rateLimitWait() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&run.RateLimitWait)))
+}
+
+// countingTransport counts the calls made to Google for the current run, a
+// batch request counting for each call it carries, and the bytes sent and
+// received.
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	run := currentRun
+	if run == nil {
+		return t.base.RoundTrip(req)
+	}
+	atomic.AddInt64(&run.APICalls, int64(requestWeight(req.Context())))
+	if req.ContentLength > 0 {
+		atomic.AddInt64(&run.BytesTransferred, req.ContentLength)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if resp != nil && resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, run: run}
+	}
+	return resp, err
+}
+
+// countingBody counts the bytes of a response body as they are read.
+type countingBody struct {
+	io.ReadCloser
+	run *runStats
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.run.BytesTransferred, int64(n))
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// instrumentClient makes a client count its requests and, with a limiter,
+// hold them back to the account's request rate.
+func instrumentClient(client *http.Client, limiter *rateLimiter) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if limiter != nil {
+		base = &limitingTransport{base: base, limiter: limiter}
+	}
+	client.Transport = &countingTransport{base: base}
+	return client
+}
+
+func queryRuns(db *sql.DB, query string, args ...any) []runStats {
+	rows, err := db.Query(`SELECT id, command, started_at, coalesce(finished_at, ''), calendars_processed, events_scanned,
+		blockers_created, blockers_updated, blockers_deleted, api_calls, bytes_transferred, rate_limit_wait_ms, errors FROM sync_runs `+query, args...)
+	if err != nil {
+		log.Fatalf("❌ Error retrieving runs from database: %v", err)
+	}
+	defer rows.Close()
+	runs := []runStats{}
+	for rows.Next() {
+		var run runStats
+		var rateLimitWaitMs int64
+		if err := rows.Scan(&run.ID, &run.Command, &run.StartedAt, &run.FinishedAt, &run.CalendarsProcessed, &run.EventsScanned,
+			&run.BlockersCreated, &run.BlockersUpdated, &run.BlockersDeleted, &run.APICalls, &run.BytesTransferred, &rateLimitWaitMs, &run.Errors); err != nil {
+			log.Fatalf("❌ Error scanning run row: %v", err)
+		}
+		run.RateLimitWait = time.Duration(rateLimitWaitMs) * time.Millisecond
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// showHistory prints the most recent runs, or the details of one run.
+func showHistory(runID string, limit int) {
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if runID != "" {
+		id, err := strconv.ParseInt(runID, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid run ID: %s", runID)
+		}
+		runs := queryRuns(db, "WHERE id = ?", id)
+		if len(runs) == 0 {
+			log.Fatalf("Run %d not found", id)
+		}
+		showRun(runs[0])
+		return
+	}
+
+	runs := queryRuns(db, "ORDER BY id DESC LIMIT ?", limit)
+	if outputFormat == "json" {
+		printJSON(runs)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tCOMMAND\tSTARTED\tDURATION\tCALENDARS\tEVENTS\tCREATED\tUPDATED\tDELETED\tAPI CALLS\tERRORS")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", run.ID, run.Command, run.StartedAt, run.duration(),
+			run.CalendarsProcessed, run.EventsScanned, run.BlockersCreated, run.BlockersUpdated, run.BlockersDeleted, run.APICalls, run.Errors)
+	}
+	w.Flush()
+}
+
+func showRun(run runStats) {
+	if outputFormat == "json" {
+		printJSON(run)
+		return
+	}
+	fmt.Printf("📊 Run %d (%s)\n", run.ID, run.Command)
+	fmt.Printf("  Started:             %s\n", run.StartedAt)
+	fmt.Printf("  Finished:            %s\n", orDash(run.FinishedAt))
+	fmt.Printf("  Duration:            %s\n", run.duration())
+	fmt.Printf("  Calendars processed: %d\n", run.CalendarsProcessed)
+	fmt.Printf("  Events scanned:      %d\n", run.EventsScanned)
+	fmt.Printf("  Blockers created:    %d\n", run.BlockersCreated)
+	fmt.Printf("  Blockers updated:    %d\n", run.BlockersUpdated)
+	fmt.Printf("  Blockers deleted:    %d\n", run.BlockersDeleted)
+	fmt.Printf("  API calls:           %d\n", run.APICalls)
+	fmt.Printf("  Bytes transferred:   %s\n", formatBytes(run.BytesTransferred))
+	fmt.Printf("  Rate limit wait:     %s\n", run.RateLimitWait)
+	fmt.Printf("  Errors:              %d\n", run.Errors)
+}
+
+func (run runStats) duration() string {
+	if run.FinishedAt == "" {
+		return "interrupted"
+	}
+	start, err1 := time.Parse(time.RFC3339, run.StartedAt)
+	end, err2 := time.Parse(time.RFC3339, run.FinishedAt)
+	if err1 != nil || err2 != nil {
+		return "-"
+	}
+	return end.Sub(start).String()
+}
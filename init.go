@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// googleCredentials is the JSON file Google Cloud Console offers for download
+// when creating a "Desktop app" OAuth client.
+type googleCredentials struct {
+	Installed struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret"`
+		RedirectURIs []string `json:"redirect_uris"`
+	} `json:"installed"`
+}
+
+var defaultAuthorizedPorts = []int{8080, 8081, 8082}
+
+func initConfig(credentialsFile string, force bool) {
+	if credentialsFile == "" {
+		log.Fatalf("Usage: gcalsync init --credentials credentials.json")
+	}
+
+	filename := configFile
+	if _, err := os.Stat(filename); err == nil && !force {
+		log.Fatalf("Config file %s already exists, use --force to overwrite it", filename)
+	}
+
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		log.Fatalf("Error reading credentials file: %v", err)
+	}
+	var credentials googleCredentials
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		log.Fatalf("Error parsing credentials file: %v", err)
+	}
+	if credentials.Installed.ClientID == "" || credentials.Installed.ClientSecret == "" {
+		log.Fatalf("Credentials file %s is not for a \"Desktop app\" OAuth client", credentialsFile)
+	}
+
+	config := Config{
+		General: GeneralConfig{
+			EventVisibility: "private",
+			AuthorizedPorts: portsFromRedirectURIs(credentials.Installed.RedirectURIs),
+		},
+		Google: GoogleConfig{
+			ClientID:     credentials.Installed.ClientID,
+			ClientSecret: credentials.Installed.ClientSecret,
+		},
+	}
+	data, err = toml.Marshal(config)
+	if err != nil {
+		log.Fatalf("Error encoding config file: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		log.Fatalf("Error writing config file: %v", err)
+	}
+	fmt.Printf("✅ Config file saved to %s\n", filename)
+
+	dbInit()
+	fmt.Println("✅ Database initialized")
+
+	fmt.Print("➕ Add a calendar now? [y/N]: ")
+	var answer string
+	fmt.Scanln(&answer)
+	if strings.ToLower(answer) != "y" {
+		return
+	}
+
+	loaded, err := readConfig(filename)
+	if err != nil {
+		log.Fatalf("Error reading config file: %v", err)
+	}
+	initSecretStore(loaded)
+	initOAuthConfig(loaded)
+	for {
+		addCalendar("", nil, "", false)
+		fmt.Print("➕ Add another calendar? [y/N]: ")
+		answer = ""
+		fmt.Scanln(&answer)
+		if strings.ToLower(answer) != "y" {
+			return
+		}
+	}
+}
+
+// portsFromRedirectURIs returns the explicit localhost ports of the redirect
+// URIs, or the default ports if none of them has one (Google's plain
+// "http://localhost" redirect accepts any port).
+func portsFromRedirectURIs(redirectURIs []string) []int {
+	var ports []int
+	for _, redirectURI := range redirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+			continue
+		}
+		port, err := strconv.Atoi(u.Port())
+		if err != nil {
+			continue
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		return defaultAuthorizedPorts
+	}
+	return ports
+}
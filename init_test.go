@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestPortsFromRedirectURIs(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirectURIs []string
+		want         []int
+	}{
+		{"none", nil, defaultAuthorizedPorts},
+		{"localhost without port", []string{"http://localhost"}, defaultAuthorizedPorts},
+		{"localhost ports", []string{"http://localhost:9000", "http://127.0.0.1:9001/callback"}, []int{9000, 9001}},
+		{"other hosts ignored", []string{"https://example.com:8443", "http://localhost:9000"}, []int{9000}},
+		{"only other hosts", []string{"https://example.com:8443"}, defaultAuthorizedPorts},
+		{"invalid URI ignored", []string{"http://localhost:port", "://bad", "http://localhost:9002"}, []int{9002}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portsFromRedirectURIs(tt.redirectURIs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("portsFromRedirectURIs(%v) = %v, want %v", tt.redirectURIs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigOmitsUnsetLimits(t *testing.T) {
+	config := Config{
+		General:  GeneralConfig{EventVisibility: "private", AuthorizedPorts: defaultAuthorizedPorts},
+		Accounts: map[string]AccountConfig{"work": {AuthFlow: "device"}},
+	}
+	data, err := toml.Marshal(config)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	for _, key := range []string{"max_retries", "retry_base_delay", "retry_max_delay", "retry_max_elapsed",
+		"requests_per_second", "request_burst", "max_creations", "max_deleted_percent"} {
+		if strings.Contains(string(data), key) {
+			t.Errorf("config has unset %s:\n%s", key, data)
+		}
+	}
+
+	config.General.MaxRetries = -1
+	config.General.RetryMaxDelay = time.Minute
+	data, _ = toml.Marshal(config)
+	for _, line := range []string{`max_retries = -1`, `retry_max_delay = "1m0s"`} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("config lacks %s:\n%s", line, data)
+		}
+	}
+}
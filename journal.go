@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Blocker changes are journaled ahead of time: an intent is recorded before
+// the change is sent to Google, and completed in the same transaction as
+// the blocker_events and audit_log bookkeeping once it went through, or
+// closed as is when Google turned it down. Intents still open at the start
+// of a run belong to a run that died half-way or lost track of a change;
+// their outcome is looked up in the calendar and the bookkeeping replayed or
+// dropped accordingly.
+
+// sqlExecer is what *sql.DB and *sql.Tx have in common for writing.
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// intent is a blocker change about to be made. Event is the content being
+// written for inserts and updates, and the blocker as it was for deletes.
+type intent struct {
+	ID               int64
+	Action           string
+	AccountName      string
+	CalendarID       string
+	EventID          string
+	OriginCalendarID string
+	OriginEventID    string
+	Event            *calendar.Event
+}
+
+// recordIntents journals changes before they are made and returns their IDs.
+func recordIntents(db *sql.DB, intents []intent) []int64 {
+	ids := make([]int64, len(intents))
+	err := inTransaction(db, func(tx *sql.Tx) error {
+		for i, in := range intents {
+			var runID int64
+			if currentRun != nil {
+				runID = currentRun.ID
+			}
+			result, err := tx.Exec(`INSERT INTO intents
+				(created_at, run_id, action, account_name, calendar_id, event_id, origin_calendar_id, origin_event_id, event)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				time.Now().Format(time.RFC3339), runID, in.Action, in.AccountName, in.CalendarID, in.EventID,
+				in.OriginCalendarID, in.OriginEventID, eventSnapshot(in.Event))
+			if err != nil {
+				return err
+			}
+			ids[i], _ = result.LastInsertId()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error recording intents: %v", err)
+	}
+	return ids
+}
+
+// completeIntent closes an intent whose change went through and is recorded.
+func completeIntent(tx sqlExecer, id int64) error {
+	if id == 0 {
+		return nil
+	}
+	_, err := tx.Exec("DELETE FROM intents WHERE id = ?", id)
+	return err
+}
+
+// failIntent closes the intent of a change that failed. Only changes Google
+// turned down are known not to have happened; the intents of changes whose
+// outcome is unknown, such as network or server errors, stay open for the
+// next run to check.
+func failIntent(db *sql.DB, id int64, err error) {
+	var googleErr *googleapi.Error
+	if !errors.As(err, &googleErr) || googleErr.Code < 400 || googleErr.Code >= 500 {
+		return
+	}
+	if err := completeIntent(db, id); err != nil {
+		currentRun.fail(err, "Error closing intent %d", id)
+	}
+}
+
+// inTransaction runs fn in a transaction, committed if fn succeeds.
+func inTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptBlockerUpdated stores the updated time of a blocker found after an
+// unfinished change. A change that went through made it newer than the
+// recorded one, which would otherwise look like an edit by hand.
+func adoptBlockerUpdated(tx sqlExecer, in intent, current *calendar.Event) error {
+	_, err := tx.Exec("UPDATE blocker_events SET blocker_updated = ? WHERE event_id = ? AND calendar_id = ?",
+		current.Updated, in.EventID, in.CalendarID)
+	return err
+}
+
+// recoverIntents settles the intents left open by an earlier run. Inserts
+// that reached Google are recorded, deletes that did are forgotten, and
+// blockers touched by an update are marked for the next sync to check again.
+// Blockers still there keep their current updated time as gcalsync's own.
+// Intents whose outcome can't be looked up stay open for the next run.
+func recoverIntents(db *sql.DB, services *serviceCache) {
+	rows, err := db.Query(`SELECT id, action, account_name, calendar_id, event_id, origin_calendar_id, origin_event_id, event
+		FROM intents ORDER BY id`)
+	if err != nil {
+		log.Fatalf("Error retrieving intents: %v", err)
+	}
+	var intents []intent
+	for rows.Next() {
+		var in intent
+		var event string
+		if err := rows.Scan(&in.ID, &in.Action, &in.AccountName, &in.CalendarID, &in.EventID, &in.OriginCalendarID, &in.OriginEventID, &event); err != nil {
+			log.Fatalf("Error scanning intent row: %v", err)
+		}
+		in.Event = parseEventSnapshot(event)
+		intents = append(intents, in)
+	}
+	rows.Close()
+	if len(intents) == 0 {
+		return
+	}
+
+	infof("🩹 Recovering %d unfinished changes from an earlier run\n", len(intents))
+	for _, in := range intents {
+		calendarService, err := services.get(in.AccountName)
+		if err != nil {
+			currentRun.fail(err, "Error checking unfinished change to blocker %s in calendar %s", in.EventID, in.CalendarID)
+			continue
+		}
+		current, err := retryCall(calendarService.Events.Get(in.CalendarID, in.EventID).Do)
+		if err != nil && !isGone(err) {
+			currentRun.fail(err, "Error checking unfinished change to blocker %s in calendar %s", in.EventID, in.CalendarID)
+			continue
+		}
+		exists := err == nil && current.Status != "cancelled"
+
+		err = inTransaction(db, func(tx *sql.Tx) error {
+			switch {
+			case in.Action == auditInsert && exists:
+				debugf("  ➕ Blocker %s was created, recording it\n", in.EventID)
+				responseStatus := "accepted"
+				if in.Event != nil && len(in.Event.Attendees) > 0 {
+					responseStatus = in.Event.Attendees[0].ResponseStatus
+				}
+				// Empty last_updated and content_hash make the next sync check it
+				_, err := tx.Exec(`INSERT OR REPLACE INTO blocker_events
+					(event_id, origin_calendar_id, calendar_id, account_name, origin_event_id, last_updated, response_status, content_hash, blocker_updated)
+					VALUES (?, ?, ?, ?, ?, '', ?, '', ?)`,
+					in.EventID, in.OriginCalendarID, in.CalendarID, in.AccountName, in.OriginEventID, responseStatus, current.Updated)
+				if err != nil {
+					return err
+				}
+				recordAudit(tx, auditEntry{
+					AccountName:      in.AccountName,
+					CalendarID:       in.CalendarID,
+					EventID:          in.EventID,
+					OriginCalendarID: in.OriginCalendarID,
+					OriginEventID:    in.OriginEventID,
+					Action:           auditInsert,
+					After:            current,
+				})
+			case in.Action == auditUpdate:
+				debugf("  🔄 Blocker %s may have been updated, checking it on the next sync\n", in.EventID)
+				_, err := tx.Exec("UPDATE blocker_events SET last_updated = '', content_hash = '' WHERE event_id = ? AND calendar_id = ?",
+					in.EventID, in.CalendarID)
+				if err != nil {
+					return err
+				}
+				if exists {
+					if err := adoptBlockerUpdated(tx, in, current); err != nil {
+						return err
+					}
+				}
+			case in.Action == auditDelete && !exists:
+				debugf("  🗑 Blocker %s was deleted, forgetting it\n", in.EventID)
+				_, err := tx.Exec("DELETE FROM blocker_events WHERE event_id = ? AND calendar_id = ?", in.EventID, in.CalendarID)
+				if err != nil {
+					return err
+				}
+				recordAudit(tx, auditEntry{
+					AccountName:      in.AccountName,
+					CalendarID:       in.CalendarID,
+					EventID:          in.EventID,
+					OriginCalendarID: in.OriginCalendarID,
+					OriginEventID:    in.OriginEventID,
+					Action:           auditDelete,
+					Before:           in.Event,
+				})
+			case in.Action == auditDelete:
+				debugf("  ↩️ Blocker %s wasn't deleted, keeping it\n", in.EventID)
+				if err := adoptBlockerUpdated(tx, in, current); err != nil {
+					return err
+				}
+			}
+			return completeIntent(tx, in.ID)
+		})
+		if err != nil {
+			currentRun.fail(err, "Error recovering change to blocker %s", in.EventID)
+		}
+	}
+}
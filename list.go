@@ -1,31 +1,157 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"text/tabwriter"
+	"time"
 )
 
-func listCalendars() {
-	db, err := openDB(".gcalsync.db")
+type calendarStatus struct {
+	AccountName        string `json:"account_name"`
+	CalendarID         string `json:"calendar_id"`
+	Summary            string `json:"summary"`
+	Role               string `json:"role"`
+	Paused             bool   `json:"paused"`
+	BlockersReceived   int    `json:"blockers_received"`
+	BlockersOriginated int    `json:"blockers_originated"`
+	TokenExpiry        string `json:"token_expiry"`
+	LastSynced         string `json:"last_synced"`
+}
+
+func getCalendarStatuses() []calendarStatus {
+	db, err := openDB(dbFile)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
 
-	fmt.Println("📋 Here's the list of calendars you are syncing:")
-
-	rows, err := db.Query("SELECT account_name, calendar_id, count(1) as num_events FROM blocker_events GROUP BY 1,2;")
+	rows, err := db.Query(`SELECT c.account_name, c.calendar_id, c.summary, c.role, c.enabled, c.last_synced,
+			(SELECT count(1) FROM blocker_events b WHERE b.calendar_id = c.calendar_id),
+			(SELECT count(1) FROM blocker_events b WHERE b.origin_calendar_id = c.calendar_id)
+		FROM calendars c ORDER BY c.account_name, c.calendar_id`)
 	if err != nil {
-		log.Fatalf("❌ Error retrieving blocker events from database: %v", err)
+		log.Fatalf("❌ Error retrieving calendars from database: %v", err)
 	}
 	defer rows.Close()
 
+	statuses := []calendarStatus{}
 	for rows.Next() {
-		var accountName, calendarID string
-		var numEvents int
-		if err := rows.Scan(&accountName, &calendarID, &numEvents); err != nil {
-			log.Fatalf("❌ Unable to read calendar record or no calendars defined: %v", err)
+		var status calendarStatus
+		var enabled bool
+		if err := rows.Scan(&status.AccountName, &status.CalendarID, &status.Summary, &status.Role, &enabled, &status.LastSynced,
+			&status.BlockersReceived, &status.BlockersOriginated); err != nil {
+			log.Fatalf("❌ Unable to read calendar record: %v", err)
+		}
+		status.Paused = !enabled
+		status.TokenExpiry = tokenExpiry(status.AccountName)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// tokenExpiry returns when the stored access token of an account expires, or
+// an empty string if that is unknown (no secret store, no token yet).
+func tokenExpiry(accountName string) string {
+	if secretStore == nil {
+		return ""
+	}
+	token, err := loadToken(accountName)
+	if err != nil || token.Expiry.IsZero() {
+		return ""
+	}
+	return token.Expiry.Format(time.RFC3339)
+}
+
+func listCalendars() {
+	statuses := getCalendarStatuses()
+
+	if outputFormat == "json" {
+		printJSON(statuses)
+		return
+	}
+
+	fmt.Println("📋 Here's the list of calendars you are syncing:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tCALENDAR\tSUMMARY\tROLE\tSTATE\tRECEIVED\tORIGINATED\tTOKEN EXPIRY\tLAST SYNC")
+	for _, status := range statuses {
+		state := "active"
+		if status.Paused {
+			state = "paused"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", status.AccountName, status.CalendarID, orDash(status.Summary),
+			status.Role, state, status.BlockersReceived, status.BlockersOriginated, orDash(status.TokenExpiry), orDash(status.LastSynced))
+	}
+	w.Flush()
+}
+
+type accountStatus struct {
+	AccountName string `json:"account_name"`
+	Type        string `json:"type"`
+	Calendars   int    `json:"calendars"`
+	Paused      int    `json:"paused"`
+	Blockers    int    `json:"blockers"`
+	TokenExpiry string `json:"token_expiry"`
+	LastSynced  string `json:"last_synced"`
+}
+
+// showStatus summarizes the calendars of each account.
+func showStatus() {
+	// The config only tells the account types, status works without it
+	config, _ := readConfig(configFile)
+
+	var accounts []*accountStatus
+	byName := make(map[string]*accountStatus)
+	for _, status := range getCalendarStatuses() {
+		account, ok := byName[status.AccountName]
+		if !ok {
+			account = &accountStatus{AccountName: status.AccountName, Type: "oauth", TokenExpiry: status.TokenExpiry}
+			if config != nil && config.isServiceAccount(status.AccountName) {
+				account.Type = "service_account"
+			}
+			byName[status.AccountName] = account
+			accounts = append(accounts, account)
+		}
+		account.Calendars++
+		if status.Paused {
+			account.Paused++
+		}
+		account.Blockers += status.BlockersReceived
+		if status.LastSynced > account.LastSynced {
+			account.LastSynced = status.LastSynced
 		}
-		fmt.Printf("  👤 %s (📅 %s) - %d\n", accountName, calendarID, numEvents)
+	}
+
+	if outputFormat == "json" {
+		if accounts == nil {
+			accounts = []*accountStatus{}
+		}
+		printJSON(accounts)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tTYPE\tCALENDARS\tPAUSED\tBLOCKERS\tTOKEN EXPIRY\tLAST SYNC")
+	for _, account := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", account.AccountName, account.Type, account.Calendars, account.Paused,
+			account.Blockers, orDash(account.TokenExpiry), orDash(account.LastSynced))
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("Error encoding JSON output: %v", err)
 	}
 }
@@ -1,36 +1,61 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: gcalsync (add|sync|desync|list)")
+	flag.Usage = usage
+	addGlobalFlags(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
 		os.Exit(1)
 	}
-	config, err := readConfig(".gcalsync.toml")
-	if err != nil {
+	name := flag.Arg(0)
+	if name == "help" {
+		help(flag.Args()[1:])
+		return
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Printf("Unknown command: %s\n", name)
+		os.Exit(1)
+	}
+	flags := newFlagSet(cmd)
+	run := cmd.setup(flags)
+	flags.Parse(flag.Args()[1:])
+	if outputFormat != "text" && outputFormat != "json" {
+		log.Fatalf("Unknown output format: %s", outputFormat)
+	}
+
+	if cmd.standalone {
+		run(flags.Args())
+		return
+	}
+
+	// Only commands talking to Google need a valid config, but reading it
+	// also tells where the database lives
+	config, err := readConfig(configFile)
+	if err != nil && cmd.needsOAuth {
 		log.Fatalf("Error reading config file: %v", err)
 	}
-	initOAuthConfig(config)
+	if config != nil && !verbositySet && config.General.Verbosity > 0 {
+		verbosity = config.General.Verbosity
+	}
 	dbInit()
-	command := os.Args[1]
-	switch command {
-	case "add":
-		addCalendar()
-	case "sync":
-		syncCalendars()
-	case "desync":
-		desyncCalendars()
-	case "cleanup":
-		cleanupCalendars()
-	case "list":
-		listCalendars()
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		os.Exit(1)
+	if config != nil {
+		initSecretStore(config)
+		initRetryPolicy(config)
+	}
+	if cmd.needsOAuth {
+		initOAuthConfig(config)
+	}
+	run(flags.Args())
+	if runFailed {
+		os.Exit(exitRunErrors)
 	}
 }
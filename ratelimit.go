@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Google's default quota is 600 requests per minute per user; staying well
+// under it leaves room for other apps using the same account.
+const (
+	defaultRequestsPerSecond = 5
+	defaultRequestBurst      = 10
+)
+
+// rateLimiter is a token bucket: requests take a token for each API call
+// they carry, tokens come back at rate per second, up to burst.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a request carrying n calls may be made and tells how long
+// that took. Concurrent callers queue up: each reserves its tokens before
+// sleeping.
+func (l *rateLimiter) wait(ctx context.Context, n int) time.Duration {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return 0
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return delay
+}
+
+// requestWeightKey is the context key of the number of API calls a request
+// carries, for batch requests.
+type requestWeightKey struct{}
+
+func withRequestWeight(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, requestWeightKey{}, n)
+}
+
+// requestWeight tells how many API calls a request counts for, one unless
+// set with withRequestWeight.
+func requestWeight(ctx context.Context) int {
+	if n, ok := ctx.Value(requestWeightKey{}).(int); ok && n > 0 {
+		return n
+	}
+	return 1
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*rateLimiter)
+)
+
+// accountLimiter returns the limiter shared by every client of an account,
+// or nil when the account isn't limited. Settings of the account section
+// win over the ones of [general].
+func accountLimiter(cfg *Config, accountName string) *rateLimiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if limiter, ok := limiters[accountName]; ok {
+		return limiter
+	}
+
+	rate, burst := float64(defaultRequestsPerSecond), defaultRequestBurst
+	if cfg.General.RequestsPerSecond != 0 {
+		rate = cfg.General.RequestsPerSecond
+	}
+	if cfg.General.RequestBurst != 0 {
+		burst = cfg.General.RequestBurst
+	}
+	account := cfg.account(accountName)
+	if account.RequestsPerSecond != 0 {
+		rate = account.RequestsPerSecond
+	}
+	if account.RequestBurst != 0 {
+		burst = account.RequestBurst
+	}
+
+	var limiter *rateLimiter
+	if rate > 0 {
+		limiter = newRateLimiter(rate, burst)
+	}
+	limiters[accountName] = limiter
+	return limiter
+}
+
+// limitingTransport holds requests back to the rate of its limiter, adding
+// the time spent waiting to the current run.
+type limitingTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *limitingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait := t.limiter.wait(req.Context(), requestWeight(req.Context()))
+	if run := currentRun; run != nil && wait > 0 {
+		atomic.AddInt64((*int64)(&run.RateLimitWait), int64(wait))
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// removeCalendar stops syncing a single calendar: the blockers it originated
+// in other calendars and the blockers it received are deleted, and so are its
+// rows in the database.
+func removeCalendar(accountName, calendarID string, dryRun bool) {
+	if accountName == "" || calendarID == "" {
+		log.Fatalf("Usage: gcalsync remove --account name --calendar id [--dry-run]")
+	}
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		log.Fatalf("Error reading config file: %v", err)
+	}
+
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var exists int
+	err = db.QueryRow("SELECT count(1) FROM calendars WHERE account_name = ? AND calendar_id = ?", accountName, calendarID).Scan(&exists)
+	if err != nil {
+		log.Fatalf("❌ Error retrieving calendar from database: %v", err)
+	}
+	if exists == 0 {
+		log.Fatalf("❌ Calendar %s is not synced for account %s", calendarID, accountName)
+	}
+
+	if dryRun {
+		fmt.Printf("🔍 Removing calendar %s (account %s) would delete:\n", calendarID, accountName)
+		blockers := calendarBlockers(db, calendarID)
+		for _, b := range blockers {
+			fmt.Printf("  🗑 Blocker event %s in calendar %s\n", b.EventID, b.CalendarID)
+		}
+		fmt.Printf("  📥 %d blocker event rows and the calendar row from the database\n", len(blockers))
+		return
+	}
+
+	infof("🚀 Removing calendar %s for account %s...\n", calendarID, accountName)
+	run := startRun(db, "remove")
+	failed := deleteCalendarBlockers(db, config, calendarID)
+	run.finish(db)
+	if failed > 0 {
+		// Keep the calendar so that running remove again retries the rest
+		log.Printf("⚠️ %d blockers of calendar %s couldn't be deleted, the calendar is kept. Run remove again to retry\n", failed, calendarID)
+		return
+	}
+
+	_, err = db.Exec("DELETE FROM calendars WHERE account_name = ? AND calendar_id = ?", accountName, calendarID)
+	if err != nil {
+		log.Fatalf("❌ Error deleting calendar from database: %v", err)
+	}
+	_, err = db.Exec("DELETE FROM tombstones WHERE calendar_id = ? OR origin_calendar_id = ?", calendarID, calendarID)
+	if err != nil {
+		log.Fatalf("❌ Error deleting tombstones from database: %v", err)
+	}
+
+	infof("Calendar %s removed successfully\n", calendarID)
+}
+
+type blocker struct {
+	EventID          string
+	CalendarID       string
+	AccountName      string
+	OriginCalendarID string
+	OriginEventID    string
+}
+
+// calendarBlockers returns the blockers calendarID originated in other
+// calendars along with the ones it received.
+func calendarBlockers(db *sql.DB, calendarID string) []blocker {
+	rows, err := db.Query(`SELECT event_id, calendar_id, account_name, coalesce(origin_calendar_id, ''), origin_event_id FROM blocker_events
+		WHERE origin_calendar_id = ? OR calendar_id = ?`, calendarID, calendarID)
+	if err != nil {
+		log.Fatalf("❌ Error retrieving blocker events from database: %v", err)
+	}
+	defer rows.Close()
+	var blockers []blocker
+	for rows.Next() {
+		var b blocker
+		if err := rows.Scan(&b.EventID, &b.CalendarID, &b.AccountName, &b.OriginCalendarID, &b.OriginEventID); err != nil {
+			log.Fatalf("❌ Error scanning blocker event row: %v", err)
+		}
+		blockers = append(blockers, b)
+	}
+	return blockers
+}
+
+// deleteCalendarBlockers deletes every blocker calendarID sent or received,
+// both from Google and from the database. Blockers that can't be deleted are
+// reported and kept; it returns how many there were.
+func deleteCalendarBlockers(db *sql.DB, config *Config, calendarID string) int {
+	services := newServiceCache(context.Background(), db, config)
+	failed := 0
+	for _, b := range calendarBlockers(db, calendarID) {
+		calendarService, err := services.get(b.AccountName)
+		if err != nil {
+			currentRun.fail(err, "Error deleting blocker event %s in calendar %s", b.EventID, b.CalendarID)
+			failed++
+			continue
+		}
+
+		// Keep what the blocker looked like for the audit log
+		before, _ := retryCall(calendarService.Events.Get(b.CalendarID, b.EventID).Do)
+
+		err = retryDo(calendarService.Events.Delete(b.CalendarID, b.EventID).Do)
+		if err != nil {
+			if !isGone(err) {
+				currentRun.fail(err, "Error deleting blocker event %s in calendar %s", b.EventID, b.CalendarID)
+				failed++
+				continue
+			}
+			infof("  ⚠️ Blocker event not found in calendar: %s\n", b.EventID)
+		} else {
+			recordAudit(db, auditEntry{
+				AccountName:      b.AccountName,
+				CalendarID:       b.CalendarID,
+				EventID:          b.EventID,
+				OriginCalendarID: b.OriginCalendarID,
+				OriginEventID:    b.OriginEventID,
+				Action:           auditDelete,
+				Before:           before,
+			})
+			currentRun.BlockersDeleted++
+			infof("  ✅ Blocker event deleted: %s\n", b.EventID)
+		}
+
+		_, err = db.Exec("DELETE FROM blocker_events WHERE event_id = ? AND calendar_id = ?", b.EventID, b.CalendarID)
+		if err != nil {
+			currentRun.fail(err, "Error deleting blocker event %s from database", b.EventID)
+			failed++
+		}
+	}
+	return failed
+}
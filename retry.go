@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// retryPolicy tells how Calendar API calls failing with rate limit or
+// transient errors are retried: exponential backoff with full jitter, waiting
+// at least as long as Google asks with Retry-After, until MaxRetries attempts
+// or MaxElapsed time have been spent.
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	MaxElapsed time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	MaxRetries: 5,
+	BaseDelay:  time.Second,
+	MaxDelay:   32 * time.Second,
+	MaxElapsed: 2 * time.Minute,
+}
+
+// apiRetry is the policy used by retryCall and retryDo.
+var apiRetry = defaultRetryPolicy
+
+// initRetryPolicy applies the retry settings of the [general] section. Unset
+// values keep their default, a negative max_retries turns retries off.
+func initRetryPolicy(config *Config) {
+	general := config.General
+	if general.MaxRetries < 0 {
+		apiRetry.MaxRetries = 0
+	} else if general.MaxRetries > 0 {
+		apiRetry.MaxRetries = general.MaxRetries
+	}
+	if general.RetryBaseDelay > 0 {
+		apiRetry.BaseDelay = general.RetryBaseDelay
+	}
+	if general.RetryMaxDelay > 0 {
+		apiRetry.MaxDelay = general.RetryMaxDelay
+	}
+	if general.RetryMaxElapsed > 0 {
+		apiRetry.MaxElapsed = general.RetryMaxElapsed
+	}
+}
+
+// retryCall runs the Do method of a Calendar API call, retrying it when it
+// fails with a rate limit or transient error.
+func retryCall[T any](do func(...googleapi.CallOption) (T, error)) (T, error) {
+	var result T
+	err := apiRetry.run(func() error {
+		var err error
+		result, err = do()
+		return err
+	})
+	return result, err
+}
+
+// retryDo is retryCall for calls returning only an error, such as deletes.
+func retryDo(do func(...googleapi.CallOption) error) error {
+	return apiRetry.run(func() error { return do() })
+}
+
+func (p retryPolicy) run(call func() error) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= p.MaxRetries {
+			return err
+		}
+		if !retryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if retryAfter := retryAfter(err); retryAfter > delay {
+			delay = retryAfter
+		}
+		if time.Since(start)+delay > p.MaxElapsed {
+			return err
+		}
+		debugf("      ⏳ %s error, retrying in %s: %v\n", classifyError(err), delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
+
+// retryable tells whether an error is worth trying again: rate limits and
+// transient server or network trouble.
+func retryable(err error) bool {
+	class := classifyError(err)
+	return class == errClassRateLimit || class == errClassTransient
+}
+
+// backoff picks a random delay up to BaseDelay doubled on every attempt,
+// capped at MaxDelay.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 30 && p.BaseDelay<<attempt < ceiling {
+		ceiling = p.BaseDelay << attempt
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// retryAfter reads the Retry-After header of a Google API error, given either
+// in seconds or as an HTTP date.
+func retryAfter(err error) time.Duration {
+	var googleErr *googleapi.Error
+	if !errors.As(err, &googleErr) || googleErr.Header == nil {
+		return 0
+	}
+	value := googleErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
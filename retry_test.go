@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{BaseDelay: time.Second, MaxDelay: 32 * time.Second}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{4, 16 * time.Second},
+		{5, 32 * time.Second},
+		{6, 32 * time.Second},
+		{40, 32 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := policy.backoff(tt.attempt); got < 0 || got >= tt.ceiling {
+				t.Fatalf("backoff(%d) = %s, want in [0, %s)", tt.attempt, got, tt.ceiling)
+			}
+		}
+	}
+	if got := (retryPolicy{}).backoff(3); got != 0 {
+		t.Errorf("backoff without delays = %s, want 0", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	withHeader := func(value string) error {
+		return &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": {value}}}
+	}
+	tests := []struct {
+		name     string
+		err      error
+		min, max time.Duration
+	}{
+		{"seconds", withHeader("7"), 7 * time.Second, 7 * time.Second},
+		{"HTTP date", withHeader(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)), 58 * time.Second, time.Minute},
+		{"invalid", withHeader("soon"), 0, 0},
+		{"no header", &googleapi.Error{Code: 503}, 0, 0},
+		{"not a Google error", errors.New("connection reset"), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.err); got < tt.min || got > tt.max {
+				t.Errorf("retryAfter = %s, want between %s and %s", got, tt.min, tt.max)
+			}
+		})
+	}
+}
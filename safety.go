@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// SafetyConfig limits how many blockers a single sync may create or delete.
+// A zero limit means no limit.
+type SafetyConfig struct {
+	MaxCreations            int `toml:"max_creations,omitzero"`
+	MaxDeletions            int `toml:"max_deletions,omitzero"`
+	MaxCreationsPerCalendar int `toml:"max_creations_per_calendar,omitzero"`
+	MaxDeletionsPerCalendar int `toml:"max_deletions_per_calendar,omitzero"`
+	MaxDeletedPercent       int `toml:"max_deleted_percent,omitzero"`
+}
+
+// Unless configured otherwise a sync may not delete more than half of the
+// blockers of a calendar. Calendars with fewer blockers than
+// minBlockersForPercent aren't checked, deleting 2 out of 3 is not unusual.
+const (
+	defaultMaxDeletedPercent = 50
+	minBlockersForPercent    = 10
+)
+
+// checkSafety compares the planned changes with the safety limits and
+// explains every limit they exceed. A token expired half-way or a calendar
+// that suddenly comes back empty would otherwise wipe every blocker.
+func checkSafety(db *sql.DB, safety SafetyConfig, ops []blockerOp) error {
+	maxDeletedPercent := safety.MaxDeletedPercent
+	if maxDeletedPercent == 0 {
+		maxDeletedPercent = defaultMaxDeletedPercent
+	}
+
+	var creations, deletions int
+	calendarCreations := make(map[string]int)
+	calendarDeletions := make(map[string]int)
+	var calendarIDs []string
+	for _, op := range ops {
+		if _, ok := calendarCreations[op.CalendarID]; !ok {
+			if _, ok := calendarDeletions[op.CalendarID]; !ok {
+				calendarIDs = append(calendarIDs, op.CalendarID)
+			}
+		}
+		switch op.Action {
+		case auditInsert:
+			creations++
+			calendarCreations[op.CalendarID]++
+		case auditDelete:
+			deletions++
+			calendarDeletions[op.CalendarID]++
+		}
+	}
+
+	var violations []error
+	if safety.MaxCreations > 0 && creations > safety.MaxCreations {
+		violations = append(violations, fmt.Errorf("  %d blockers would be created, the limit is %d (max_creations)", creations, safety.MaxCreations))
+	}
+	if safety.MaxDeletions > 0 && deletions > safety.MaxDeletions {
+		violations = append(violations, fmt.Errorf("  %d blockers would be deleted, the limit is %d (max_deletions)", deletions, safety.MaxDeletions))
+	}
+	for _, calendarID := range calendarIDs {
+		created, deleted := calendarCreations[calendarID], calendarDeletions[calendarID]
+		if safety.MaxCreationsPerCalendar > 0 && created > safety.MaxCreationsPerCalendar {
+			violations = append(violations, fmt.Errorf("  %d blockers would be created in %s, the limit is %d (max_creations_per_calendar)",
+				created, calendarID, safety.MaxCreationsPerCalendar))
+		}
+		if safety.MaxDeletionsPerCalendar > 0 && deleted > safety.MaxDeletionsPerCalendar {
+			violations = append(violations, fmt.Errorf("  %d blockers would be deleted in %s, the limit is %d (max_deletions_per_calendar)",
+				deleted, calendarID, safety.MaxDeletionsPerCalendar))
+		}
+		if deleted == 0 || maxDeletedPercent >= 100 {
+			continue
+		}
+		var existing int
+		err := db.QueryRow("SELECT COUNT(*) FROM blocker_events WHERE calendar_id = ?", calendarID).Scan(&existing)
+		if err != nil {
+			log.Fatalf("Error counting blocker events: %v", err)
+		}
+		if existing >= minBlockersForPercent && deleted*100 > existing*maxDeletedPercent {
+			violations = append(violations, fmt.Errorf("  %d of the %d blockers in %s would be deleted, more than %d%% (max_deleted_percent)",
+				deleted, existing, calendarID, maxDeletedPercent))
+		}
+	}
+	return errors.Join(violations...)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB creates an up to date database in a temporary directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	saved := dbFile
+	dbFile = filepath.Join(t.TempDir(), "gcalsync.db")
+	t.Cleanup(func() { dbFile = saved })
+	dbInit()
+	db, err := openDB(dbFile)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func safetyOps(action, calendarID string, n int) []blockerOp {
+	ops := make([]blockerOp, n)
+	for i := range ops {
+		ops[i] = blockerOp{Action: action, CalendarID: calendarID, EventID: fmt.Sprintf("%s-%d", calendarID, i)}
+	}
+	return ops
+}
+
+func TestCheckSafety(t *testing.T) {
+	db := openTestDB(t)
+	// "big" has 20 blockers, "small" fewer than minBlockersForPercent
+	for calendarID, count := range map[string]int{"big": 20, "small": minBlockersForPercent - 1} {
+		for i := 0; i < count; i++ {
+			_, err := db.Exec("INSERT INTO blocker_events (event_id, calendar_id, origin_event_id) VALUES (?, ?, ?)",
+				fmt.Sprintf("%s-%d", calendarID, i), calendarID, fmt.Sprint(i))
+			if err != nil {
+				t.Fatalf("insert blocker: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		safety SafetyConfig
+		ops    []blockerOp
+		want   []string // limits reported, none when empty
+	}{
+		{"no changes", SafetyConfig{}, nil, nil},
+		{"half of a calendar", SafetyConfig{}, safetyOps(auditDelete, "big", 10), nil},
+		{"more than half of a calendar", SafetyConfig{}, safetyOps(auditDelete, "big", 11), []string{"max_deleted_percent"}},
+		{"all of a small calendar", SafetyConfig{}, safetyOps(auditDelete, "small", minBlockersForPercent-1), nil},
+		{"custom percent", SafetyConfig{MaxDeletedPercent: 20}, safetyOps(auditDelete, "big", 5), []string{"max_deleted_percent"}},
+		{"percent check off", SafetyConfig{MaxDeletedPercent: 100}, safetyOps(auditDelete, "big", 20), nil},
+		{"creations at the limit", SafetyConfig{MaxCreations: 3}, safetyOps(auditInsert, "big", 3), nil},
+		{"creations over the limit", SafetyConfig{MaxCreations: 3}, safetyOps(auditInsert, "big", 4), []string{"max_creations"}},
+		{"deletions over the limit", SafetyConfig{MaxDeletions: 2}, safetyOps(auditDelete, "small", 3), []string{"max_deletions"}},
+		{
+			"per calendar limits",
+			SafetyConfig{MaxCreationsPerCalendar: 2, MaxDeletionsPerCalendar: 2},
+			append(safetyOps(auditInsert, "big", 3), safetyOps(auditDelete, "small", 3)...),
+			[]string{"max_creations_per_calendar", "max_deletions_per_calendar"},
+		},
+		{"updates don't count", SafetyConfig{MaxCreations: 1, MaxDeletions: 1}, safetyOps(auditUpdate, "big", 20), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSafety(db, tt.safety, tt.ops)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("checkSafety = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("checkSafety = nil, want %v exceeded", tt.want)
+			}
+			for _, limit := range tt.want {
+				if !strings.Contains(err.Error(), "("+limit+")") {
+					t.Errorf("checkSafety = %v, want %s exceeded", err, limit)
+				}
+			}
+			if got := strings.Count(err.Error(), "\n") + 1; got != len(tt.want) {
+				t.Errorf("checkSafety reported %d limits, want %d: %v", got, len(tt.want), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Calendar roles: a source calendar only has its events mirrored elsewhere,
+// a sink calendar only receives blockers, and "both" does both (the default).
+const (
+	roleBoth   = "both"
+	roleSource = "source"
+	roleSink   = "sink"
+)
+
+func isValidRole(role string) bool {
+	return role == roleBoth || role == roleSource || role == roleSink
+}
+
+func isSource(role string) bool {
+	return role != roleSink
+}
+
+func isSink(role string) bool {
+	return role != roleSource
+}
+
+func grantedScopesKey(accountName string) string {
+	return "granted_scopes/" + accountName
+}
+
+func getCalendarRolesFromDB(db *sql.DB) map[string]string {
+	roles := make(map[string]string)
+	rows, err := db.Query("SELECT calendar_id, role FROM calendars")
+	if err != nil {
+		log.Fatalf("Error retrieving calendar roles: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var calendarID, role string
+		if err := rows.Scan(&calendarID, &role); err != nil {
+			log.Fatalf("Error scanning calendar row: %v", err)
+		}
+		roles[calendarID] = role
+	}
+	return roles
+}
+
+// accountScopes returns the minimal scopes needed for the given account
+// calendar roles: reading events for pure sources, read/write otherwise.
+func accountScopes(roles []string) []string {
+	for _, role := range roles {
+		if isSink(role) {
+			return []string{calendar.CalendarEventsScope}
+		}
+	}
+	return []string{calendar.CalendarEventsReadonlyScope}
+}
+
+func accountRolesFromDB(db *sql.DB, accountName string) []string {
+	var roles []string
+	rows, err := db.Query("SELECT role FROM calendars WHERE account_name = ?", accountName)
+	if err != nil {
+		log.Fatalf("Error retrieving calendar roles: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			log.Fatalf("Error scanning calendar row: %v", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// scopeCovers reports whether a granted scope implies the needed one.
+func scopeCovers(granted, needed string) bool {
+	if granted == needed || granted == calendar.CalendarScope {
+		return true
+	}
+	if needed == calendar.CalendarEventsReadonlyScope {
+		return granted == calendar.CalendarEventsScope || granted == calendar.CalendarReadonlyScope
+	}
+	return false
+}
+
+func missingScopes(granted, needed []string) []string {
+	var missing []string
+	for _, n := range needed {
+		covered := false
+		for _, g := range granted {
+			if scopeCovers(g, n) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// loadGrantedScopes returns the scopes the stored token of accountName was
+// granted. Tokens saved before scopes were tracked were always issued for
+// full calendar access.
+func loadGrantedScopes(accountName string) []string {
+	scopes, err := secretStore.Get(grantedScopesKey(accountName))
+	if errors.Is(err, errSecretNotFound) {
+		return []string{calendar.CalendarScope}
+	}
+	if err != nil {
+		log.Fatalf("Error reading granted scopes for account %s: %v", accountName, err)
+	}
+	return strings.Fields(scopes)
+}
+
+// saveGrantedScopes records the scopes Google reports for token, falling back
+// to the ones that were requested.
+func saveGrantedScopes(accountName string, token *oauth2.Token, requested []string) error {
+	scopes, _ := token.Extra("scope").(string)
+	if scopes == "" {
+		scopes = strings.Join(requested, " ")
+	}
+	return secretStore.Set(grantedScopesKey(accountName), scopes)
+}
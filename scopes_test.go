@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestScopeCovers(t *testing.T) {
+	tests := []struct {
+		granted, needed string
+		want            bool
+	}{
+		{calendar.CalendarEventsScope, calendar.CalendarEventsScope, true},
+		{calendar.CalendarScope, calendar.CalendarEventsScope, true},
+		{calendar.CalendarScope, calendar.CalendarReadonlyScope, true},
+		{calendar.CalendarEventsScope, calendar.CalendarEventsReadonlyScope, true},
+		{calendar.CalendarReadonlyScope, calendar.CalendarEventsReadonlyScope, true},
+		{calendar.CalendarEventsReadonlyScope, calendar.CalendarEventsScope, false},
+		{calendar.CalendarReadonlyScope, calendar.CalendarEventsScope, false},
+		{calendar.CalendarEventsScope, calendar.CalendarReadonlyScope, false},
+		{calendar.CalendarEventsScope, calendar.CalendarScope, false},
+	}
+	for _, tt := range tests {
+		if got := scopeCovers(tt.granted, tt.needed); got != tt.want {
+			t.Errorf("scopeCovers(%s, %s) = %v, want %v", tt.granted, tt.needed, got, tt.want)
+		}
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		name            string
+		granted, needed []string
+		want            []string
+	}{
+		{"nothing needed", []string{calendar.CalendarEventsReadonlyScope}, nil, nil},
+		{"full access from older versions", []string{calendar.CalendarScope},
+			[]string{calendar.CalendarEventsScope, calendar.CalendarReadonlyScope}, nil},
+		{"read-only token for a new sink", []string{calendar.CalendarEventsReadonlyScope},
+			[]string{calendar.CalendarEventsScope}, []string{calendar.CalendarEventsScope}},
+		{"calendar list for discovery", []string{calendar.CalendarEventsScope},
+			[]string{calendar.CalendarEventsScope, calendar.CalendarReadonlyScope}, []string{calendar.CalendarReadonlyScope}},
+		{"no token", nil, []string{calendar.CalendarEventsReadonlyScope}, []string{calendar.CalendarEventsReadonlyScope}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingScopes(tt.granted, tt.needed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingScopes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
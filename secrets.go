@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// SecretStore keeps the OAuth client secret and the per-account tokens.
+// Keys are plain strings: "client_secret" and "token/<account name>".
+type SecretStore interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+type SecretsConfig struct {
+	Backend   string   `toml:"backend,omitempty"`    // db (default), command, env or file
+	Command   []string `toml:"command,omitempty"`    // command backend: program and leading arguments
+	File      string   `toml:"file,omitempty"`       // file backend: path to the secrets file
+	EnvPrefix string   `toml:"env_prefix,omitempty"` // env backend: variable prefix, GCALSYNC_ by default
+}
+
+const clientSecretKey = "client_secret"
+
+var errSecretNotFound = errors.New("secret not found")
+
+var secretStore SecretStore
+
+func tokenKey(accountName string) string {
+	return "token/" + accountName
+}
+
+func initSecretStore(config *Config) {
+	store, err := newSecretStore(config.Secrets)
+	if err != nil {
+		log.Fatalf("Error initializing secret store: %v", err)
+	}
+	secretStore = store
+}
+
+func newSecretStore(cfg SecretsConfig) (SecretStore, error) {
+	switch cfg.Backend {
+	case "", "db":
+		db, err := openDB(dbFile)
+		if err != nil {
+			return nil, err
+		}
+		return &dbSecretStore{db: db}, nil
+	case "command":
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("secrets backend \"command\" requires a command")
+		}
+		return &commandSecretStore{command: cfg.Command}, nil
+	case "env":
+		prefix := cfg.EnvPrefix
+		if prefix == "" {
+			prefix = "GCALSYNC_"
+		}
+		return &envSecretStore{prefix: prefix}, nil
+	case "file":
+		path := cfg.File
+		if path == "" {
+			path = configDir + ".gcalsync.secrets"
+		}
+		return &fileSecretStore{path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown secrets backend %q", cfg.Backend)
+}
+
+func loadToken(accountName string) (*oauth2.Token, error) {
+	tokenJSON, err := secretStore.Get(tokenKey(accountName))
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(tokenJSON), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// dbSecretStore keeps tokens in the tokens table, as gcalsync always did,
+// and any other secret in the secrets table.
+type dbSecretStore struct {
+	db *sql.DB
+}
+
+func (s *dbSecretStore) Get(key string) (string, error) {
+	var value string
+	var err error
+	if accountName, ok := strings.CutPrefix(key, "token/"); ok {
+		err = s.db.QueryRow("SELECT token FROM tokens WHERE account_name = ?", accountName).Scan(&value)
+	} else {
+		err = s.db.QueryRow("SELECT value FROM secrets WHERE name = ?", key).Scan(&value)
+	}
+	if err == sql.ErrNoRows {
+		return "", errSecretNotFound
+	}
+	return value, err
+}
+
+func (s *dbSecretStore) Set(key, value string) error {
+	var err error
+	if accountName, ok := strings.CutPrefix(key, "token/"); ok {
+		_, err = s.db.Exec("INSERT OR REPLACE INTO tokens (account_name, token) VALUES (?, ?)", accountName, value)
+	} else {
+		_, err = s.db.Exec("INSERT OR REPLACE INTO secrets (name, value) VALUES (?, ?)", key, value)
+	}
+	return err
+}
+
+func (s *dbSecretStore) Delete(key string) error {
+	var err error
+	if accountName, ok := strings.CutPrefix(key, "token/"); ok {
+		_, err = s.db.Exec("DELETE FROM tokens WHERE account_name = ?", accountName)
+	} else {
+		_, err = s.db.Exec("DELETE FROM secrets WHERE name = ?", key)
+	}
+	return err
+}
+
+// commandSecretStore delegates to an external program such as a `pass` or
+// vault wrapper. The program is invoked as `<command...> get|set|delete <key>`:
+//   - get prints the secret to stdout and exits 0, or exits 1 if the key does not exist;
+//   - set reads the secret from stdin;
+//   - delete removes the key, exiting 0 or 1 if it did not exist.
+//
+// Any other non-zero exit status is treated as an error.
+type commandSecretStore struct {
+	command []string
+}
+
+func (s *commandSecretStore) run(action, key string, stdin string) (string, int, error) {
+	args := append(append([]string{}, s.command[1:]...), action, key)
+	cmd := exec.Command(s.command[0], args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return "", exitErr.ExitCode(), fmt.Errorf("secret command %s %s failed: %v: %s", action, key, err, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return "", -1, err
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), 0, nil
+}
+
+func (s *commandSecretStore) Get(key string) (string, error) {
+	value, code, err := s.run("get", key, "")
+	if code == 1 {
+		return "", errSecretNotFound
+	}
+	return value, err
+}
+
+func (s *commandSecretStore) Set(key, value string) error {
+	_, _, err := s.run("set", key, value)
+	return err
+}
+
+func (s *commandSecretStore) Delete(key string) error {
+	_, code, err := s.run("delete", key, "")
+	if code == 1 {
+		return nil
+	}
+	return err
+}
+
+// envSecretStore reads secrets from environment variables named after the
+// key, e.g. GCALSYNC_CLIENT_SECRET or GCALSYNC_TOKEN_WORK. The environment
+// cannot be written back, so refreshed tokens only live until the process exits.
+type envSecretStore struct {
+	prefix string
+}
+
+func (s *envSecretStore) variable(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+	return s.prefix + strings.ToUpper(name)
+}
+
+func (s *envSecretStore) Get(key string) (string, error) {
+	value, ok := os.LookupEnv(s.variable(key))
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return value, nil
+}
+
+func (s *envSecretStore) Set(key, value string) error {
+	return os.Setenv(s.variable(key), value)
+}
+
+func (s *envSecretStore) Delete(key string) error {
+	return os.Unsetenv(s.variable(key))
+}
+
+// fileSecretStore keeps all secrets in a single JSON file which must not be
+// readable by anyone but its owner.
+type fileSecretStore struct {
+	path string
+}
+
+func (s *fileSecretStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("secrets file %s has permissions %04o, expected 0600", s.path, perm)
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("error parsing secrets file %s: %v", s.path, err)
+	}
+	return secrets, nil
+}
+
+func (s *fileSecretStore) save(secrets map[string]string) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileSecretStore) Get(key string) (string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[key]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return value, nil
+}
+
+func (s *fileSecretStore) Set(key, value string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[key] = value
+	return s.save(secrets)
+}
+
+func (s *fileSecretStore) Delete(key string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(secrets, key)
+	return s.save(secrets)
+}
@@ -3,9 +3,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 	"time"
 
@@ -13,46 +18,189 @@ import (
 	"google.golang.org/api/option"
 )
 
-func syncCalendars() {
-	config, err := readConfig(".gcalsync.toml")
+// What to do with blockers the destination calendar's owner edited
+const (
+	editedBlockersKeep      = "keep"
+	editedBlockersOverwrite = "overwrite"
+)
+
+// Listing asks only for the fields sync uses, in pages as large as Google
+// allows; events are processed a page at a time.
+const (
+	maxEventsPerPage = 2500
+	syncEventFields  = "nextPageToken,summary,items(id,status,summary,description,start,end,updated,eventType,attendees(email,responseStatus))"
+)
+
+// blockerOp is a change sync is going to make to a destination calendar.
+// Sync plans every change first so that the safety limits can be checked
+// before anything is touched.
+type blockerOp struct {
+	Action           string // auditInsert, auditUpdate or auditDelete
+	AccountName      string // owner of the destination calendar
+	CalendarID       string // destination calendar
+	EventID          string // existing blocker, for updates and deletes
+	OriginCalendarID string
+	OriginEventID    string
+	OriginUpdated    string          // origin event's updated time, stored as last_updated
+	ResponseStatus   string          // calendar owner's response to the origin event
+	ContentHash      string          // blockerHash of Blocker
+	BlockerUpdated   string          // updated time of the blocker after gcalsync last wrote it
+	Blocker          *calendar.Event // content to write, for inserts and updates
+}
+
+// serviceCache creates one HTTP client and calendar service per account and
+// reuses them. An account whose client can't be created keeps failing with
+// the same error, without asking again.
+type serviceCache struct {
+	ctx      context.Context
+	db       *sql.DB
+	config   *Config
+	clients  map[string]*http.Client
+	services map[string]*calendar.Service
+	errs     map[string]error
+}
+
+func newServiceCache(ctx context.Context, db *sql.DB, config *Config) *serviceCache {
+	return &serviceCache{ctx: ctx, db: db, config: config,
+		clients: make(map[string]*http.Client), services: make(map[string]*calendar.Service), errs: make(map[string]error)}
+}
+
+func (c *serviceCache) client(accountName string) (*http.Client, error) {
+	if err, ok := c.errs[accountName]; ok {
+		return nil, err
+	}
+	if client, ok := c.clients[accountName]; ok {
+		return client, nil
+	}
+	client, err := getClient(c.ctx, c.db, accountName, c.config)
+	if err != nil {
+		c.errs[accountName] = err
+		return nil, err
+	}
+	c.clients[accountName] = client
+	return client, nil
+}
+
+func (c *serviceCache) get(accountName string) (*calendar.Service, error) {
+	if calendarService, ok := c.services[accountName]; ok {
+		return calendarService, nil
+	}
+	client, err := c.client(accountName)
+	if err != nil {
+		return nil, err
+	}
+	calendarService, err := calendar.NewService(c.ctx, option.WithHTTPClient(client))
+	if err != nil {
+		c.errs[accountName] = err
+		return nil, err
+	}
+	c.services[accountName] = calendarService
+	return calendarService, nil
+}
+
+func syncCalendars(force bool) {
+	config, err := readConfig(configFile)
 	if err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
+	if policy := config.General.EditedBlockers; policy != "" && policy != editedBlockersKeep && policy != editedBlockersOverwrite {
+		log.Fatalf("Unknown edited_blockers policy: %s", policy)
+	}
 	useReminders := config.General.DisableReminders
 	eventVisibility := config.General.EventVisibility
 	ignoreBirthdays := config.General.IgnoreBirthdays
 
-	db, err := openDB(".gcalsync.db")
+	db, err := openDB(dbFile)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
 
 	calendars := getCalendarsFromDB(db)
+	roles := getCalendarRolesFromDB(db)
 
 	ctx := context.Background()
-	fmt.Println("🚀 Starting calendar synchronization...")
+	services := newServiceCache(ctx, db, config)
+	run := startRun(db, "sync")
+	infof("🚀 Starting calendar synchronization...\n")
+	recoverIntents(db, services)
+
+	// Calendars that ran into errors keep the time they were last synced
+	// without any
+	var ops []blockerOp
+	failed := make(map[string]bool)
 	for accountName, calendarIDs := range calendars {
-		fmt.Printf("📅 Syncing calendars for account: %s\n", accountName)
-		client := getClient(ctx, oauthConfig, db, accountName, config)
-		calendarService, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+		infof("📅 Syncing calendars for account: %s\n", accountName)
+		calendarService, err := services.get(accountName)
+		if err == nil {
+			calendarService, err = tokenExpired(accountName, calendarService, ctx, config)
+		}
 		if err != nil {
-			log.Fatalf("Error creating calendar client: %v", err)
+			run.fail(err, "Skipping calendars of account %s", accountName)
+			for _, calendarID := range calendarIDs {
+				failed[calendarID] = true
+			}
+			continue
 		}
+		services.services[accountName] = calendarService
 
 		for _, calendarID := range calendarIDs {
-			fmt.Printf("  ↪️ Syncing calendar: %s\n", calendarID)
-			syncCalendar(db, calendarService, calendarID, calendars, accountName, useReminders, eventVisibility, ignoreBirthdays)
+			if !isSource(roles[calendarID]) {
+				infof("  ↪️ Skipping sink-only calendar: %s\n", calendarID)
+				continue
+			}
+			infof("  ↪️ Syncing calendar: %s\n", calendarID)
+			run.CalendarsProcessed++
+			errorsBefore := run.Errors
+			calendarOps, err := planCalendar(db, calendarService, calendarID, calendars, roles, useReminders, eventVisibility, ignoreBirthdays)
+			if err != nil {
+				run.fail(err, "Skipping calendar %s", calendarID)
+				failed[calendarID] = true
+				continue
+			}
+			if run.Errors > errorsBefore {
+				failed[calendarID] = true
+			}
+			ops = append(ops, calendarOps...)
 		}
-		fmt.Println("✅ Calendar synchronization completed successfully!")
 	}
 
-	fmt.Println("Calendars synced successfully")
+	if err := checkSafety(db, config.Safety, ops); err != nil {
+		if !force {
+			log.Printf("❌ Safety limits exceeded:\n%v\n", err)
+			run.fail(err, "Sync aborted, nothing was changed")
+			run.finish(db)
+			log.Fatalf("❌ Aborting sync. If these changes are expected, run `gcalsync sync --force`.")
+		}
+		log.Printf("⚠️ Safety limits exceeded, proceeding because of --force:\n%v\n", err)
+	}
+
+	for calendarID := range applyBlockerOps(db, services, ops) {
+		failed[calendarID] = true
+	}
+	infof("✅ Calendar synchronization completed!\n")
+
+	syncedAt := time.Now().Format(time.RFC3339)
+	for accountName, calendarIDs := range calendars {
+		for _, calendarID := range calendarIDs {
+			if failed[calendarID] {
+				continue
+			}
+			_, err = db.Exec("UPDATE calendars SET last_synced = ? WHERE account_name = ? AND calendar_id = ?", syncedAt, accountName, calendarID)
+			if err != nil {
+				run.fail(err, "Error recording sync time of calendar %s", calendarID)
+			}
+		}
+	}
+	run.finish(db)
+
+	infof("Calendars synced successfully\n")
 }
 
+// getCalendarsFromDB returns the calendars of every account, leaving out paused ones.
 func getCalendarsFromDB(db *sql.DB) map[string][]string {
 	calendars := make(map[string][]string)
-	rows, _ := db.Query("SELECT account_name, calendar_id FROM calendars")
+	rows, _ := db.Query("SELECT account_name, calendar_id FROM calendars WHERE enabled = 1")
 	defer rows.Close()
 	for rows.Next() {
 		var accountName, calendarID string
@@ -64,14 +212,12 @@ func getCalendarsFromDB(db *sql.DB) map[string][]string {
 	return calendars
 }
 
-func syncCalendar(db *sql.DB, calendarService *calendar.Service, calendarID string, calendars map[string][]string, accountName string, useReminders bool, eventVisibility string, ignoreBirthdays bool) {
-	config, err := readConfig(".gcalsync.toml")
-	if err != nil {
-		log.Fatalf("Error reading config file: %v", err)
-	}
-
-	ctx := context.Background()
-	calendarService = tokenExpired(db, accountName, calendarService, ctx)
+// planCalendar works out which blockers the events of calendarID need in the
+// other calendars, and which existing blockers have lost their origin event.
+// It only reads from Google. When the events can't be listed no changes are
+// planned at all, an error must not look like an empty calendar.
+func planCalendar(db *sql.DB, calendarService *calendar.Service, calendarID string, calendars map[string][]string, roles map[string]string, useReminders bool, eventVisibility string, ignoreBirthdays bool) ([]blockerOp, error) {
+	var ops []blockerOp
 	pageToken := ""
 
 	now := time.Now()
@@ -81,19 +227,24 @@ func syncCalendar(db *sql.DB, calendarService *calendar.Service, calendarID stri
 	timeMax := endOfNextMonth.Format(time.RFC3339)
 
 	var allEventsId = map[string]bool{}
+	var calendarSummary string
 
 	for {
-		fmt.Printf("    📥 Retrieving events for calendar: %s\n", calendarID)
-		events, err := calendarService.Events.List(calendarID).
+		debugf("    📥 Retrieving events for calendar: %s\n", calendarID)
+		events, err := retryCall(calendarService.Events.List(calendarID).
 			PageToken(pageToken).
 			SingleEvents(true).
 			TimeMin(timeMin).
 			TimeMax(timeMax).
 			OrderBy("startTime").
-			Do()
+			MaxResults(maxEventsPerPage).
+			Fields(syncEventFields).
+			Do)
 		if err != nil {
-			log.Fatalf("Error retrieving events: %v", err)
+			return nil, fmt.Errorf("error retrieving events: %w", err)
 		}
+		calendarSummary = events.Summary
+		currentRun.EventsScanned += len(events.Items)
 
 		for _, event := range events.Items {
 			allEventsId[event.Id] = true
@@ -104,20 +255,22 @@ func syncCalendar(db *sql.DB, calendarService *calendar.Service, calendarID stri
 
 			// Check if this is a birthday event and skip if ignore_birthdays is enabled
 			if ignoreBirthdays && event.EventType == "birthday" {
-				fmt.Printf("    🎂 Skipping birthday event: %s\n", event.Summary)
+				debugf("    🎂 Skipping birthday event: %s\n", event.Summary)
 				continue
 			}
 
 			if !strings.Contains(event.Summary, "O_o") {
-				fmt.Printf("    ✨ Syncing event: %s\n", event.Summary)
+				debugf("    ✨ Syncing event: %s\n", event.Summary)
 				for otherAccountName, calendarIDs := range calendars {
 					for _, otherCalendarID := range calendarIDs {
-						if otherCalendarID != calendarID {
+						if otherCalendarID != calendarID && isSink(roles[otherCalendarID]) {
 							var existingBlockerEventID string
 							var last_updated string
 							var originCalendarID string
 							var responseStatus string
-							err := db.QueryRow("SELECT event_id, last_updated, origin_calendar_id, response_status FROM blocker_events WHERE calendar_id = ? AND origin_event_id = ?", otherCalendarID, event.Id).Scan(&existingBlockerEventID, &last_updated, &originCalendarID, &responseStatus)
+							var contentHash string
+							var blockerUpdated string
+							err := db.QueryRow("SELECT event_id, last_updated, origin_calendar_id, response_status, content_hash, blocker_updated FROM blocker_events WHERE calendar_id = ? AND origin_event_id = ?", otherCalendarID, event.Id).Scan(&existingBlockerEventID, &last_updated, &originCalendarID, &responseStatus, &contentHash, &blockerUpdated)
 
 							// Get original event's response status for the calendar owner
 							originalResponseStatus := "accepted" // default
@@ -130,18 +283,6 @@ func syncCalendar(db *sql.DB, calendarService *calendar.Service, calendarID stri
 								}
 							}
 
-							// Only skip if event exists, is up to date, and response status hasn't changed
-							if err == nil && last_updated == event.Updated && originCalendarID == calendarID && responseStatus == originalResponseStatus {
-								fmt.Printf("      ⚠️ Blocker event already exists for origin event ID %s in calendar %s and up to date\n", event.Id, otherCalendarID)
-								continue
-							}
-
-							client := getClient(ctx, oauthConfig, db, otherAccountName, config)
-							otherCalendarService, err := calendar.NewService(ctx, option.WithHTTPClient(client))
-							if err != nil {
-								log.Fatalf("Error creating calendar client: %v", err)
-							}
-
 							blockerSummary := fmt.Sprintf("O_o %s", event.Summary)
 							blockerDescription := event.Description
 
@@ -172,31 +313,40 @@ func syncCalendar(db *sql.DB, calendarService *calendar.Service, calendarID stri
 								blockerEvent.Visibility = eventVisibility
 							}
 
-							var res *calendar.Event
-
-							if existingBlockerEventID != "" {
-								res, err = otherCalendarService.Events.Update(otherCalendarID, existingBlockerEventID, blockerEvent).Do()
-							} else {
-								res, err = otherCalendarService.Events.Insert(otherCalendarID, blockerEvent).Do()
+							// Only skip if the blocker exists and would look the same. Blockers
+							// saved before content hashes were stored compare the origin's
+							// updated time and response status instead
+							if buried(db, otherCalendarID, calendarID, event.Id, blockerEvent) {
+								debugf("      🪦 Blocker for origin event ID %s was deleted in calendar %s, not recreating it\n", event.Id, otherCalendarID)
+								continue
 							}
-							if err == nil {
-								fmt.Printf("      ➕ Blocker event created or updated: %s (Response: %s)\n", blockerEvent.Summary, originalResponseStatus)
-								fmt.Printf("      📅 Destination calendar: %s\n", otherCalendarID)
-								result, err := db.Exec(`INSERT OR REPLACE INTO blocker_events
-									(event_id, origin_calendar_id, calendar_id, account_name, origin_event_id, last_updated, response_status)
-									VALUES (?, ?, ?, ?, ?, ?, ?)`,
-									res.Id, calendarID, otherCalendarID, otherAccountName, event.Id, event.Updated, originalResponseStatus)
-								if err != nil {
-									log.Printf("Error inserting blocker event into database: %v\n", err)
-								} else {
-									rowsAffected, _ := result.RowsAffected()
-									fmt.Printf("      📥 Blocker event inserted into database. Rows affected: %d\n", rowsAffected)
-								}
+
+							hash := blockerHash(blockerEvent)
+							if err == nil && originCalendarID == calendarID &&
+								(contentHash == hash || (contentHash == "" && last_updated == event.Updated && responseStatus == originalResponseStatus)) {
+								debugf("      ⚠️ Blocker event already exists for origin event ID %s in calendar %s and up to date\n", event.Id, otherCalendarID)
+								continue
 							}
 
-							if err != nil {
-								log.Fatalf("Error creating blocker event: %v", err)
+							op := blockerOp{
+								Action:           auditInsert,
+								AccountName:      otherAccountName,
+								CalendarID:       otherCalendarID,
+								OriginCalendarID: calendarID,
+								OriginEventID:    event.Id,
+								OriginUpdated:    event.Updated,
+								ResponseStatus:   originalResponseStatus,
+								ContentHash:      hash,
+								Blocker:          blockerEvent,
 							}
+							if existingBlockerEventID != "" {
+								op.Action = auditUpdate
+								op.EventID = existingBlockerEventID
+								op.BlockerUpdated = blockerUpdated
+							} else {
+								blockerEvent.Id = blockerID(calendarID, event.Id, otherCalendarID)
+							}
+							ops = append(ops, op)
 						}
 					}
 				}
@@ -208,68 +358,350 @@ func syncCalendar(db *sql.DB, calendarService *calendar.Service, calendarID stri
 		}
 	}
 
-	// Delete blocker events that not exists from this calendar in other calendars
-	fmt.Printf("    🗑 Deleting blocker events that no longer exist in calendar %s from other calendars…\n", calendarID)
+	// Fill in the summary of calendars added by ID, keeping aliases picked on discovery
+	if calendarSummary != "" {
+		_, err := db.Exec("UPDATE calendars SET summary = ? WHERE calendar_id = ? AND summary = ''", calendarSummary, calendarID)
+		if err != nil {
+			currentRun.fail(err, "Error saving summary of calendar %s", calendarID)
+		}
+	}
+
+	// Find blocker events whose origin no longer exists in this calendar
+	debugf("    🔍 Looking for blocker events that no longer exist in calendar %s…\n", calendarID)
 	for otherAccountName, calendarIDs := range calendars {
 		for _, otherCalendarID := range calendarIDs {
-			if otherCalendarID != calendarID {
-				client := getClient(ctx, oauthConfig, db, otherAccountName, config)
-				otherCalendarService, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+			if otherCalendarID != calendarID && isSink(roles[otherCalendarID]) {
 				rows, err := db.Query("SELECT event_id, origin_event_id FROM blocker_events WHERE calendar_id = ? AND origin_calendar_id = ?", otherCalendarID, calendarID)
 				if err != nil {
-					log.Fatalf("Error retrieving blocker events: %v", err)
+					return nil, fmt.Errorf("error retrieving blocker events: %w", err)
 				}
-				eventsToDelete := make([]string, 0)
-
-				defer rows.Close()
+				originEventIDs := make(map[string]string)
 				for rows.Next() {
 					var eventID string
 					var originEventID string
 					if err := rows.Scan(&eventID, &originEventID); err != nil {
-						log.Fatalf("Error scanning blocker event row: %v", err)
+						rows.Close()
+						return nil, fmt.Errorf("error scanning blocker event row: %w", err)
 					}
+					originEventIDs[eventID] = originEventID
+				}
+				rows.Close()
 
+				for eventID, originEventID := range originEventIDs {
 					if val := allEventsId[originEventID]; !val {
-
-						res, err := calendarService.Events.Get(calendarID, originEventID).Do()
-						if err != nil || res == nil || res.Status == "cancelled" {
-							fmt.Printf("    🚩 Event marked for deletion: %s\n", eventID)
-							eventsToDelete = append(eventsToDelete, eventID)
+						res, err := retryCall(calendarService.Events.Get(calendarID, originEventID).Fields("status").Do)
+						if err != nil && !isGone(err) {
+							currentRun.fail(err, "Error checking origin event %s, keeping its blockers", originEventID)
+							continue
+						}
+						if err != nil || res.Status == "cancelled" {
+							debugf("    🚩 Event marked for deletion: %s\n", eventID)
+							ops = append(ops, blockerOp{
+								Action:           auditDelete,
+								AccountName:      otherAccountName,
+								CalendarID:       otherCalendarID,
+								EventID:          eventID,
+								OriginCalendarID: calendarID,
+								OriginEventID:    originEventID,
+							})
 						}
 					}
 				}
+			}
+		}
+	}
+	return ops, nil
+}
 
-				for _, eventID := range eventsToDelete {
-					fmt.Printf("      🗑 Deleting blocker event: %s\n", eventID)
-					res, err := otherCalendarService.Events.Get(otherCalendarID, eventID).Do()
+// blockerHash sums up what a blocker looks like, so that changes to the
+// origin event that don't show on the blocker, such as another attendee's
+// RSVP, don't cause an update.
+func blockerHash(blocker *calendar.Event) string {
+	var responseStatus string
+	if len(blocker.Attendees) > 0 {
+		responseStatus = blocker.Attendees[0].ResponseStatus
+	}
+	data, _ := json.Marshal(struct {
+		Summary, Description, Status, Visibility, Transparency, ResponseStatus string
+		Start, End                                                             *calendar.EventDateTime
+	}{blocker.Summary, blocker.Description, blocker.Status, blocker.Visibility, blocker.Transparency, responseStatus,
+		blocker.Start, blocker.End})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
 
-					alreadyDeleted := false
+// blockerPatch makes the body of a PATCH bringing a blocker up to date.
+// Empty fields are left out of JSON, which a PATCH takes as unchanged: the
+// fields gcalsync sets are sent as null instead, so that a description or
+// visibility removed from the origin goes away on the blocker too, and so
+// does the time of the other kind when an event turns all-day or back.
+func blockerPatch(blocker *calendar.Event) *calendar.Event {
+	patch := *blocker
+	patch.NullFields = nil
+	if patch.Description == "" {
+		patch.NullFields = append(patch.NullFields, "Description")
+	}
+	if patch.Visibility == "" {
+		patch.NullFields = append(patch.NullFields, "Visibility")
+	}
+	patch.Start = patchDateTime(blocker.Start)
+	patch.End = patchDateTime(blocker.End)
+	return &patch
+}
 
-					if err != nil {
-						alreadyDeleted = strings.Contains(err.Error(), "410")
-						if !alreadyDeleted {
-							log.Fatalf("Error retrieving blocker event: %v", err)
-						}
-					}
+func patchDateTime(t *calendar.EventDateTime) *calendar.EventDateTime {
+	if t == nil {
+		return nil
+	}
+	patch := *t
+	if patch.Date != "" {
+		patch.NullFields = []string{"DateTime"}
+	} else {
+		patch.NullFields = []string{"Date"}
+	}
+	return &patch
+}
 
-					if !alreadyDeleted {
-						err = otherCalendarService.Events.Delete(otherCalendarID, eventID).Do()
-						if err != nil {
-							if res.Status != "cancelled" {
-								log.Fatalf("Error deleting blocker event: %v", err)
-							} else {
-								fmt.Printf("     ❗️ Event already deleted in the other calendar: %s\n", eventID)
-							}
-						}
-					}
-					_, err = db.Exec("DELETE FROM blocker_events WHERE event_id = ?", eventID)
-					if err != nil {
-						log.Fatalf("Error deleting blocker event from database: %v", err)
-					}
+// blockerID derives the ID of a new blocker from its origin event and
+// destination calendar, so that inserting it twice can't create two
+// blockers. Google takes IDs made of base32hex digits.
+func blockerID(originCalendarID, originEventID, calendarID string) string {
+	sum := sha256.Sum256([]byte(originCalendarID + "\x00" + originEventID + "\x00" + calendarID))
+	return strings.ToLower(base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:]))
+}
+
+// blockerEdited tells whether a blocker changed since gcalsync last wrote
+// it. Blockers written before their updated time was kept count as unedited.
+func blockerEdited(op blockerOp, current *calendar.Event) bool {
+	ours, err1 := time.Parse(time.RFC3339, op.BlockerUpdated)
+	theirs, err2 := time.Parse(time.RFC3339, current.Updated)
+	return err1 == nil && err2 == nil && theirs.After(ours)
+}
+
+// applyBlockerOps carries out the planned changes and keeps blocker_events,
+// the audit log and the run statistics up to date. Changes are sent in
+// batches, one destination calendar at a time. A failing change is reported
+// and the others are still carried out; the calendars that had failing
+// changes are returned.
+func applyBlockerOps(db *sql.DB, services *serviceCache, ops []blockerOp) map[string]bool {
+	type destination struct{ AccountName, CalendarID string }
+	var destinations []destination
+	opsByDestination := make(map[destination][]blockerOp)
+	for _, op := range ops {
+		dest := destination{op.AccountName, op.CalendarID}
+		if _, ok := opsByDestination[dest]; !ok {
+			destinations = append(destinations, dest)
+		}
+		opsByDestination[dest] = append(opsByDestination[dest], op)
+	}
+	overwriteEdited := services.config.General.EditedBlockers == editedBlockersOverwrite
+	failed := make(map[string]bool)
+	for _, dest := range destinations {
+		client, err := services.client(dest.AccountName)
+		if err != nil {
+			currentRun.fail(err, "Skipping %d blocker changes in calendar %s", len(opsByDestination[dest]), dest.CalendarID)
+			failed[dest.CalendarID] = true
+			continue
+		}
+		errorsBefore := currentRun.Errors
+		applyCalendarOps(db, client, opsByDestination[dest], overwriteEdited)
+		if currentRun.Errors > errorsBefore {
+			failed[dest.CalendarID] = true
+		}
+	}
+	return failed
+}
+
+// applyCalendarOps applies the changes to one destination calendar: a first
+// batch fetches the blockers about to be updated or deleted, for the audit
+// log and to spot blockers edited by the calendar's owner, a second one makes
+// the changes. Updates only patch the fields gcalsync sets, edited blockers
+// are left alone unless overwriteEdited.
+func applyCalendarOps(db *sql.DB, client *http.Client, ops []blockerOp, overwriteEdited bool) {
+	var gets []batchRequest
+	var getIndexes []int
+	for i, op := range ops {
+		if op.Action != auditInsert {
+			gets = append(gets, eventRequest("GET", op.CalendarID, op.EventID, nil))
+			getIndexes = append(getIndexes, i)
+		}
+	}
+	before := make([]*calendar.Event, len(ops))
+	alreadyDeleted := make([]bool, len(ops))
+	skip := make([]bool, len(ops))
+	for j, result := range runBatch(client, gets) {
+		i := getIndexes[j]
+		switch {
+		case ops[i].Action == auditUpdate && blockerDeleted(result):
+			// Deleted by hand: remember that rather than recreating it
+			infof("      🪦 Blocker %s was deleted in calendar %s, not recreating it\n", ops[i].EventID, ops[i].CalendarID)
+			buryBlocker(db, ops[i], result.Event)
+			skip[i] = true
+		case result.Err == nil:
+			before[i] = result.Event
+			if ops[i].Action == auditUpdate && !overwriteEdited && blockerEdited(ops[i], result.Event) {
+				infof("      ✋ Blocker %s was edited in calendar %s, leaving it as is\n", ops[i].EventID, ops[i].CalendarID)
+				skip[i] = true
+			}
+		case ops[i].Action == auditDelete && isGone(result.Err):
+			alreadyDeleted[i] = true
+		default:
+			// Without the blocker an update can't tell whether its owner
+			// edited it, and a delete would go unaudited
+			currentRun.fail(result.Err, "Error retrieving blocker event %s in calendar %s", ops[i].EventID, ops[i].CalendarID)
+			skip[i] = true
+		}
+	}
+
+	var changes []batchRequest
+	var changeIndexes []int
+	for i, op := range ops {
+		if skip[i] || alreadyDeleted[i] {
+			continue
+		}
+		switch op.Action {
+		case auditInsert:
+			changes = append(changes, eventRequest("POST", op.CalendarID, "", op.Blocker))
+		case auditUpdate:
+			changes = append(changes, eventRequest("PATCH", op.CalendarID, op.EventID, blockerPatch(op.Blocker)))
+		case auditDelete:
+			infof("      🗑 Deleting blocker event: %s\n", op.EventID)
+			changes = append(changes, eventRequest("DELETE", op.CalendarID, op.EventID, nil))
+		}
+		changeIndexes = append(changeIndexes, i)
+	}
+	// Journal the changes before making them
+	intents := make([]intent, len(changeIndexes))
+	for j, i := range changeIndexes {
+		op := ops[i]
+		intents[j] = intent{Action: op.Action, AccountName: op.AccountName, CalendarID: op.CalendarID, EventID: op.EventID,
+			OriginCalendarID: op.OriginCalendarID, OriginEventID: op.OriginEventID, Event: op.Blocker}
+		switch op.Action {
+		case auditInsert:
+			intents[j].EventID = op.Blocker.Id
+		case auditDelete:
+			intents[j].Event = before[i]
+		}
+	}
+	intentIDs := make([]int64, len(ops))
+	for j, id := range recordIntents(db, intents) {
+		intentIDs[changeIndexes[j]] = id
+	}
+
+	results := make([]*batchResult, len(ops))
+	for j, result := range runBatch(client, changes) {
+		results[changeIndexes[j]] = &result
+	}
+
+	// A blocker ID already taken means an earlier run created the blocker
+	// but didn't get to record it, or the blocker was deleted since. Adopt
+	// it: bring it up to date, and back if it was deleted
+	var adoptions []batchRequest
+	var adoptionIndexes []int
+	for i, op := range ops {
+		if op.Action == auditInsert && results[i] != nil && isConflict(results[i].Err) {
+			debugf("      🔁 Blocker %s already exists in calendar %s, adopting it\n", op.Blocker.Id, op.CalendarID)
+			blocker := blockerPatch(op.Blocker)
+			blocker.Status = "confirmed"
+			adoptions = append(adoptions, eventRequest("PATCH", op.CalendarID, op.Blocker.Id, blocker))
+			adoptionIndexes = append(adoptionIndexes, i)
+		}
+	}
+	for j, result := range runBatch(client, adoptions) {
+		results[adoptionIndexes[j]] = &result
+	}
 
-					fmt.Printf("      ✅ Blocker event deleted: %s\n", res.Summary)
+	// Changes whose outcome is unknown keep their intent open, the next run
+	// checks what became of them
+	for i, op := range ops {
+		if skip[i] {
+			continue
+		}
+		switch op.Action {
+		case auditInsert, auditUpdate:
+			if results[i].Err != nil {
+				currentRun.fail(results[i].Err, "Error writing blocker for event %s in calendar %s", op.OriginEventID, op.CalendarID)
+				failIntent(db, intentIDs[i], results[i].Err)
+				continue
+			}
+			res := results[i].Event
+			infof("      ➕ Blocker event created or updated: %s (Response: %s)\n", op.Blocker.Summary, op.ResponseStatus)
+			debugf("      📅 Destination calendar: %s\n", op.CalendarID)
+
+			err := inTransaction(db, func(tx *sql.Tx) error {
+				_, err := tx.Exec(`INSERT OR REPLACE INTO blocker_events
+					(event_id, origin_calendar_id, calendar_id, account_name, origin_event_id, last_updated, response_status, content_hash, blocker_updated)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					res.Id, op.OriginCalendarID, op.CalendarID, op.AccountName, op.OriginEventID, op.OriginUpdated, op.ResponseStatus, op.ContentHash, res.Updated)
+				if err != nil {
+					return err
 				}
+				if op.Action == auditInsert {
+					clearTombstone(tx, op.CalendarID, op.OriginCalendarID, op.OriginEventID)
+				}
+				recordAudit(tx, auditEntry{
+					AccountName:      op.AccountName,
+					CalendarID:       op.CalendarID,
+					EventID:          res.Id,
+					OriginCalendarID: op.OriginCalendarID,
+					OriginEventID:    op.OriginEventID,
+					Action:           op.Action,
+					Before:           before[i],
+					After:            res,
+				})
+				return completeIntent(tx, intentIDs[i])
+			})
+			if err != nil {
+				currentRun.fail(err, "Error inserting blocker event %s into database", res.Id)
+				continue
 			}
+			debugf("      📥 Blocker event inserted into database\n")
+			if op.Action == auditUpdate {
+				currentRun.BlockersUpdated++
+			} else {
+				currentRun.BlockersCreated++
+			}
+
+		case auditDelete:
+			deleted := false
+			if !alreadyDeleted[i] {
+				err := results[i].Err
+				switch {
+				case err == nil:
+					deleted = true
+				case isGone(err) || (before[i] != nil && before[i].Status == "cancelled"):
+					infof("     ❗️ Event already deleted in the other calendar: %s\n", op.EventID)
+				default:
+					currentRun.fail(err, "Error deleting blocker event %s in calendar %s", op.EventID, op.CalendarID)
+					failIntent(db, intentIDs[i], err)
+					continue
+				}
+			}
+			err := inTransaction(db, func(tx *sql.Tx) error {
+				_, err := tx.Exec("DELETE FROM blocker_events WHERE event_id = ?", op.EventID)
+				if err != nil {
+					return err
+				}
+				if deleted {
+					recordAudit(tx, auditEntry{
+						AccountName:      op.AccountName,
+						CalendarID:       op.CalendarID,
+						EventID:          op.EventID,
+						OriginCalendarID: op.OriginCalendarID,
+						OriginEventID:    op.OriginEventID,
+						Action:           auditDelete,
+						Before:           before[i],
+					})
+				}
+				return completeIntent(tx, intentIDs[i])
+			})
+			if err != nil {
+				currentRun.fail(err, "Error deleting blocker event %s from database", op.EventID)
+				continue
+			}
+
+			currentRun.BlockersDeleted++
+			infof("      ✅ Blocker event deleted: %s\n", op.EventID)
 		}
 	}
 }
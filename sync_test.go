@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestBlockerPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocker *calendar.Event
+		want    map[string]any
+	}{
+		{
+			name: "timed event, description and visibility cleared",
+			blocker: &calendar.Event{
+				Summary: "O_o Meeting",
+				Start:   &calendar.EventDateTime{DateTime: "2024-05-01T10:00:00Z"},
+				End:     &calendar.EventDateTime{DateTime: "2024-05-01T11:00:00Z"},
+			},
+			want: map[string]any{
+				"summary":     "O_o Meeting",
+				"description": nil,
+				"visibility":  nil,
+				"start":       map[string]any{"date": nil, "dateTime": "2024-05-01T10:00:00Z"},
+				"end":         map[string]any{"date": nil, "dateTime": "2024-05-01T11:00:00Z"},
+			},
+		},
+		{
+			name: "all-day event",
+			blocker: &calendar.Event{
+				Summary:     "O_o Holiday",
+				Description: "Away",
+				Visibility:  "private",
+				Start:       &calendar.EventDateTime{Date: "2024-05-01"},
+				End:         &calendar.EventDateTime{Date: "2024-05-02"},
+			},
+			want: map[string]any{
+				"summary":     "O_o Holiday",
+				"description": "Away",
+				"visibility":  "private",
+				"start":       map[string]any{"date": "2024-05-01", "dateTime": nil},
+				"end":         map[string]any{"date": "2024-05-02", "dateTime": nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(blockerPatch(tt.blocker))
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patch body = %s, want %v", data, tt.want)
+			}
+			if tt.blocker.NullFields != nil || tt.blocker.Start.NullFields != nil {
+				t.Errorf("blockerPatch changed the blocker it was given")
+			}
+		})
+	}
+}
+
+func TestBlockerID(t *testing.T) {
+	// Google takes event IDs of 5 to 1024 base32hex digits
+	digits := regexp.MustCompile(`^[0-9a-v]+$`)
+	tests := []struct{ originCalendarID, originEventID, calendarID string }{
+		{"me@example.com", "abc123", "team@group.calendar.google.com"},
+		{"me@example.com", "abc123_20240501T100000Z", "other@example.com"},
+		{"", "", ""},
+		{"ünïcode@example.com", "event with spaces", "UPPER@EXAMPLE.COM"},
+	}
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		id := blockerID(tt.originCalendarID, tt.originEventID, tt.calendarID)
+		if !digits.MatchString(id) || len(id) < 5 || len(id) > 1024 {
+			t.Errorf("blockerID(%q, %q, %q) = %q, not a valid event ID", tt.originCalendarID, tt.originEventID, tt.calendarID, id)
+		}
+		if again := blockerID(tt.originCalendarID, tt.originEventID, tt.calendarID); again != id {
+			t.Errorf("blockerID is not deterministic: %q then %q", id, again)
+		}
+		if seen[id] {
+			t.Errorf("blockerID(%q, %q, %q) = %q, already used", tt.originCalendarID, tt.originEventID, tt.calendarID, id)
+		}
+		seen[id] = true
+	}
+	// The separator keeps different splits of the same string apart
+	if blockerID("ab", "c", "d") == blockerID("a", "bc", "d") {
+		t.Errorf("blockerID collides for different origins")
+	}
+}
+
+func TestApplyCalendarOpsSkipsUpdatesOfUnreadableBlockers(t *testing.T) {
+	db := openTestDB(t)
+	currentRun = &runStats{}
+	t.Cleanup(func() { currentRun = nil })
+
+	var sent []string
+	client := newBatchServer(t, func(calls []batchCall) []batchReply {
+		var replies []batchReply
+		for _, call := range calls {
+			sent = append(sent, call.Method+" "+call.Path)
+			status := http.StatusOK
+			if call.Method == "GET" && strings.HasSuffix(call.Path, "/forbidden") {
+				status = http.StatusForbidden
+			}
+			replies = append(replies, batchReply{ContentID: call.ContentID, Status: status,
+				Event: &calendar.Event{Id: path.Base(call.Path), Updated: "2024-05-01T10:00:00Z"}})
+		}
+		return replies
+	})
+
+	blocker := &calendar.Event{Summary: "O_o Meeting", Start: &calendar.EventDateTime{Date: "2024-05-01"}, End: &calendar.EventDateTime{Date: "2024-05-02"}}
+	ops := []blockerOp{
+		{Action: auditUpdate, AccountName: "work", CalendarID: "cal", EventID: "forbidden", OriginEventID: "a", BlockerUpdated: "2024-05-01T10:00:00Z", Blocker: blocker},
+		{Action: auditUpdate, AccountName: "work", CalendarID: "cal", EventID: "readable", OriginEventID: "b", BlockerUpdated: "2024-05-01T10:00:00Z", Blocker: blocker},
+	}
+	applyCalendarOps(db, client, ops, false)
+
+	for _, call := range sent {
+		if call == "PATCH /calendar/v3/calendars/cal/events/forbidden" {
+			t.Errorf("patched a blocker that couldn't be read first")
+		}
+	}
+	if sent[len(sent)-1] != "PATCH /calendar/v3/calendars/cal/events/readable" {
+		t.Errorf("calls = %v, want the readable blocker patched", sent)
+	}
+	if currentRun.Errors != 1 || currentRun.BlockersUpdated != 1 {
+		t.Errorf("%d errors and %d updates, want 1 and 1", currentRun.Errors, currentRun.BlockersUpdated)
+	}
+}
+
+func TestApplyCalendarOpsClosesIntentsOfRefusedChanges(t *testing.T) {
+	db := openTestDB(t)
+	currentRun = &runStats{}
+	t.Cleanup(func() { currentRun = nil })
+	saved := apiRetry
+	apiRetry = retryPolicy{}
+	t.Cleanup(func() { apiRetry = saved })
+
+	client := newBatchServer(t, func(calls []batchCall) []batchReply {
+		var replies []batchReply
+		for _, call := range calls {
+			status := http.StatusOK
+			switch {
+			case call.Method == "GET":
+			case strings.HasSuffix(call.Path, "/denied"):
+				status = http.StatusForbidden
+			case strings.HasSuffix(call.Path, "/flaky"):
+				status = http.StatusServiceUnavailable
+			}
+			replies = append(replies, batchReply{ContentID: call.ContentID, Status: status,
+				Event: &calendar.Event{Id: path.Base(call.Path), Updated: "2024-05-01T10:00:00Z"}})
+		}
+		return replies
+	})
+
+	blocker := &calendar.Event{Summary: "O_o Meeting", Start: &calendar.EventDateTime{Date: "2024-05-01"}, End: &calendar.EventDateTime{Date: "2024-05-02"}}
+	ops := []blockerOp{
+		{Action: auditUpdate, AccountName: "work", CalendarID: "cal", EventID: "denied", OriginEventID: "a", BlockerUpdated: "2024-05-01T10:00:00Z", Blocker: blocker},
+		{Action: auditDelete, AccountName: "work", CalendarID: "cal", EventID: "flaky", OriginEventID: "b"},
+	}
+	applyCalendarOps(db, client, ops, false)
+
+	// The refused update is known not to have happened, the delete may have
+	var open []string
+	rows, err := db.Query("SELECT event_id FROM intents")
+	if err != nil {
+		t.Fatalf("query intents: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID string
+		rows.Scan(&eventID)
+		open = append(open, eventID)
+	}
+	if !reflect.DeepEqual(open, []string{"flaky"}) {
+		t.Errorf("open intents = %v, want only the one of the flaky delete", open)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// tombstone marks a blocker deleted by hand in its calendar. Sync doesn't
+// recreate it until the origin event moves to other times, or the tombstone
+// is cleared.
+type tombstone struct {
+	CalendarID       string `json:"calendar_id"`
+	AccountName      string `json:"account_name"`
+	EventID          string `json:"event_id"`
+	OriginCalendarID string `json:"origin_calendar_id"`
+	OriginEventID    string `json:"origin_event_id"`
+	CreatedAt        string `json:"created_at"`
+}
+
+// blockerTimesHash sums up when a blocker takes place, the one change to an
+// origin event that brings a buried blocker back. Times are compared in UTC,
+// calendars in other time zones show the same instant with other offsets.
+func blockerTimesHash(blocker *calendar.Event) string {
+	data, _ := json.Marshal([]string{utcTime(blocker.Start), utcTime(blocker.End)})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func utcTime(t *calendar.EventDateTime) string {
+	switch {
+	case t == nil:
+		return ""
+	case t.Date != "":
+		return t.Date
+	}
+	parsed, err := time.Parse(time.RFC3339, t.DateTime)
+	if err != nil {
+		return t.DateTime
+	}
+	return parsed.UTC().Format(time.RFC3339)
+}
+
+// blockerDeleted tells whether a fetched blocker no longer exists.
+func blockerDeleted(result batchResult) bool {
+	if result.Err != nil {
+		return isGone(result.Err)
+	}
+	return result.Event != nil && result.Event.Status == "cancelled"
+}
+
+// buryBlocker records a tombstone for a blocker deleted by hand and forgets
+// the blocker itself. The tombstone keeps the times the blocker had, not the
+// ones it was about to get: if the origin event moved since, the next sync
+// brings the blocker back.
+func buryBlocker(db *sql.DB, op blockerOp, deleted *calendar.Event) {
+	_, err := db.Exec(`INSERT OR REPLACE INTO tombstones
+		(calendar_id, account_name, event_id, origin_calendar_id, origin_event_id, times_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		op.CalendarID, op.AccountName, op.EventID, op.OriginCalendarID, op.OriginEventID, blockerTimesHash(deletedBlocker(db, op, deleted)),
+		time.Now().Format(time.RFC3339))
+	if err != nil {
+		currentRun.fail(err, "Error recording tombstone for blocker %s", op.EventID)
+		return
+	}
+	_, err = db.Exec("DELETE FROM blocker_events WHERE event_id = ? AND calendar_id = ?", op.EventID, op.CalendarID)
+	if err != nil {
+		currentRun.fail(err, "Error deleting blocker event %s from database", op.EventID)
+	}
+}
+
+// deletedBlocker finds what a blocker deleted by hand looked like. Google
+// still returns recently deleted events, older ones are found in the audit
+// log as gcalsync last wrote them. Failing both, the blocker is taken to have
+// had the times it was about to get.
+func deletedBlocker(db *sql.DB, op blockerOp, deleted *calendar.Event) *calendar.Event {
+	if deleted != nil && deleted.Start != nil && deleted.End != nil {
+		return deleted
+	}
+	var snapshot string
+	err := db.QueryRow("SELECT after FROM audit_log WHERE event_id = ? AND calendar_id = ? AND after != '' ORDER BY id DESC LIMIT 1",
+		op.EventID, op.CalendarID).Scan(&snapshot)
+	if err == nil {
+		if written := parseEventSnapshot(snapshot); written != nil && written.Start != nil && written.End != nil {
+			return written
+		}
+	}
+	return op.Blocker
+}
+
+// buried tells whether the blocker of an origin event in a calendar has a
+// tombstone that still applies. A tombstone whose origin event has moved
+// doesn't; it is dropped once the new blocker is written.
+func buried(db *sql.DB, calendarID, originCalendarID, originEventID string, blocker *calendar.Event) bool {
+	var timesHash string
+	err := db.QueryRow("SELECT times_hash FROM tombstones WHERE calendar_id = ? AND origin_calendar_id = ? AND origin_event_id = ?",
+		calendarID, originCalendarID, originEventID).Scan(&timesHash)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("Error retrieving tombstone: %v", err)
+	}
+	return timesHash == blockerTimesHash(blocker)
+}
+
+func clearTombstone(db sqlExecer, calendarID, originCalendarID, originEventID string) {
+	_, err := db.Exec("DELETE FROM tombstones WHERE calendar_id = ? AND origin_calendar_id = ? AND origin_event_id = ?",
+		calendarID, originCalendarID, originEventID)
+	if err != nil {
+		currentRun.fail(err, "Error deleting tombstone for event %s", originEventID)
+	}
+}
+
+// tombstoneCondition builds the WHERE clause picking tombstones of a calendar,
+// whether it received or sent the blocker, and of an origin event.
+func tombstoneCondition(calendarID, originEventID string) (string, []any) {
+	condition := "1 = 1"
+	var args []any
+	if calendarID != "" {
+		condition += " AND (calendar_id = ? OR origin_calendar_id = ?)"
+		args = append(args, calendarID, calendarID)
+	}
+	if originEventID != "" {
+		condition += " AND origin_event_id = ?"
+		args = append(args, originEventID)
+	}
+	return condition, args
+}
+
+func listTombstones(calendarID, originEventID string) {
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	condition, args := tombstoneCondition(calendarID, originEventID)
+	rows, err := db.Query(`SELECT calendar_id, account_name, event_id, origin_calendar_id, origin_event_id, created_at
+		FROM tombstones WHERE `+condition+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		log.Fatalf("❌ Error retrieving tombstones: %v", err)
+	}
+	defer rows.Close()
+	tombstones := []tombstone{}
+	for rows.Next() {
+		var t tombstone
+		if err := rows.Scan(&t.CalendarID, &t.AccountName, &t.EventID, &t.OriginCalendarID, &t.OriginEventID, &t.CreatedAt); err != nil {
+			log.Fatalf("❌ Error scanning tombstone row: %v", err)
+		}
+		tombstones = append(tombstones, t)
+	}
+
+	if outputFormat == "json" {
+		printJSON(tombstones)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DELETED\tACCOUNT\tCALENDAR\tBLOCKER\tORIGIN")
+	for _, t := range tombstones {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s/%s\n", t.CreatedAt, t.AccountName, t.CalendarID, t.EventID, t.OriginCalendarID, t.OriginEventID)
+	}
+	w.Flush()
+}
+
+// clearTombstones forgets manual deletions, so that the next sync recreates
+// those blockers.
+func clearTombstones(calendarID, originEventID string) {
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	condition, args := tombstoneCondition(calendarID, originEventID)
+	result, err := db.Exec("DELETE FROM tombstones WHERE "+condition, args...)
+	if err != nil {
+		log.Fatalf("❌ Error deleting tombstones: %v", err)
+	}
+	cleared, _ := result.RowsAffected()
+	infof("🧹 Cleared %d tombstones, the next sync recreates their blockers\n", cleared)
+}
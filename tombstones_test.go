@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func timedBlocker(start, end string) *calendar.Event {
+	return &calendar.Event{Start: &calendar.EventDateTime{DateTime: start}, End: &calendar.EventDateTime{DateTime: end}}
+}
+
+func TestBlockerTimesHash(t *testing.T) {
+	base := timedBlocker("2024-05-01T10:00:00Z", "2024-05-01T11:00:00Z")
+	tests := []struct {
+		name    string
+		blocker *calendar.Event
+		same    bool
+	}{
+		{"same instant in another time zone", timedBlocker("2024-05-01T12:00:00+02:00", "2024-05-01T13:00:00+02:00"), true},
+		{"time zone name ignored", &calendar.Event{
+			Start: &calendar.EventDateTime{DateTime: "2024-05-01T10:00:00Z", TimeZone: "Europe/Berlin"},
+			End:   &calendar.EventDateTime{DateTime: "2024-05-01T11:00:00Z", TimeZone: "Europe/Berlin"},
+		}, true},
+		{"moved", timedBlocker("2024-05-01T11:00:00Z", "2024-05-01T12:00:00Z"), false},
+		{"longer", timedBlocker("2024-05-01T10:00:00Z", "2024-05-01T12:00:00Z"), false},
+		{"all-day", &calendar.Event{Start: &calendar.EventDateTime{Date: "2024-05-01"}, End: &calendar.EventDateTime{Date: "2024-05-02"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockerTimesHash(tt.blocker) == blockerTimesHash(base); got != tt.same {
+				t.Errorf("same times = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestBuryBlockerKeepsDeletedTimes(t *testing.T) {
+	db := openTestDB(t)
+	currentRun = &runStats{}
+	t.Cleanup(func() { currentRun = nil })
+
+	deletedTimes := timedBlocker("2024-05-01T10:00:00Z", "2024-05-01T11:00:00Z")
+	movedTimes := timedBlocker("2024-05-01T15:00:00Z", "2024-05-01T16:00:00Z")
+	tests := []struct {
+		name    string
+		deleted *calendar.Event // what Google returned for the deleted blocker
+		audited *calendar.Event // what gcalsync last wrote
+	}{
+		{"deleted event returned", deletedTimes, nil},
+		{"deleted event gone, written one audited", nil, deletedTimes},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := blockerOp{Action: auditUpdate, AccountName: "work", CalendarID: "sink", EventID: fmt.Sprintf("blocker%d", i),
+				OriginCalendarID: "source", OriginEventID: fmt.Sprintf("origin%d", i), Blocker: movedTimes}
+			if tt.audited != nil {
+				recordAudit(db, auditEntry{CalendarID: op.CalendarID, EventID: op.EventID, Action: auditInsert, After: tt.audited})
+			}
+			buryBlocker(db, op, tt.deleted)
+			if !buried(db, op.CalendarID, op.OriginCalendarID, op.OriginEventID, deletedTimes) {
+				t.Errorf("blocker at its deleted times isn't buried")
+			}
+			if buried(db, op.CalendarID, op.OriginCalendarID, op.OriginEventID, movedTimes) {
+				t.Errorf("blocker whose origin moved since it was deleted is still buried")
+			}
+		})
+	}
+	if currentRun.Errors > 0 {
+		t.Errorf("burying blockers failed: %v", currentRun.failures)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// undoRun reverses the calendar mutations of a run, using the audit log as
+// its journal: inserted blockers are deleted, updated ones get their previous
+// content back and deleted ones are recreated. Without a run ID the latest
+// sync or desync run is undone.
+func undoRun(runID string) {
+	config, err := readConfig(configFile)
+	if err != nil {
+		log.Fatalf("Error reading config file: %v", err)
+	}
+
+	db, err := openDB(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var target runStats
+	var undoneBy int64
+	if runID == "" {
+		err = db.QueryRow(`SELECT id, command, coalesce(undone_by, 0) FROM sync_runs
+			WHERE command IN ('sync', 'desync') ORDER BY id DESC LIMIT 1`).Scan(&target.ID, &target.Command, &undoneBy)
+	} else {
+		id, parseErr := strconv.ParseInt(runID, 10, 64)
+		if parseErr != nil {
+			log.Fatalf("Invalid run ID: %s", runID)
+		}
+		err = db.QueryRow(`SELECT id, command, coalesce(undone_by, 0) FROM sync_runs WHERE id = ?`, id).Scan(&target.ID, &target.Command, &undoneBy)
+	}
+	if err == sql.ErrNoRows {
+		log.Fatalf("❌ No run to undo")
+	}
+	if err != nil {
+		log.Fatalf("❌ Error retrieving run from database: %v", err)
+	}
+	if undoneBy != 0 {
+		log.Fatalf("❌ Run %d was already undone by run %d", target.ID, undoneBy)
+	}
+
+	// Newest first, so that a blocker updated and then deleted is recreated
+	// before its content is restored
+	entries := queryAudit(db, auditFilter{RunID: target.ID})
+
+	ctx := context.Background()
+	run := startRun(db, "undo")
+	infof("⏪ Undoing run %d (%s): %d changes\n", target.ID, target.Command, len(entries))
+
+	services := newServiceCache(ctx, db, config)
+
+	// Recreated blockers get new IDs, later (older) entries must follow them.
+	// Changes reversed by an earlier, partial undo are skipped
+	renamed := make(map[string]string)
+	undone := undoneChanges(db, entries)
+	for _, entry := range entries {
+		if eventID, ok := undone[entry.ID]; ok && entry.Action == auditDelete {
+			renamed[entry.EventID] = eventID
+		}
+	}
+
+	for _, entry := range entries {
+		if _, ok := undone[entry.ID]; ok {
+			continue
+		}
+		calendarService, err := services.get(entry.AccountName)
+		if err != nil {
+			run.fail(err, "Error undoing change to blocker %s in calendar %s", entry.EventID, entry.CalendarID)
+			continue
+		}
+		eventID := entry.EventID
+		if newID, ok := renamed[eventID]; ok {
+			eventID = newID
+		}
+
+		switch entry.Action {
+		case auditInsert:
+			before, _ := calendarService.Events.Get(entry.CalendarID, eventID).Do()
+			err := calendarService.Events.Delete(entry.CalendarID, eventID).Do()
+			if err != nil && !isGone(err) {
+				run.fail(err, "Error deleting blocker event %s", eventID)
+				continue
+			}
+			_, err = db.Exec("DELETE FROM blocker_events WHERE event_id = ? AND calendar_id = ?", eventID, entry.CalendarID)
+			if err != nil {
+				run.fail(err, "Error deleting blocker event %s from database", eventID)
+				continue
+			}
+			markUndone(db, run, entry, eventID)
+			run.BlockersDeleted++
+			recordAudit(db, auditEntry{
+				AccountName:      entry.AccountName,
+				CalendarID:       entry.CalendarID,
+				EventID:          eventID,
+				OriginCalendarID: entry.OriginCalendarID,
+				OriginEventID:    entry.OriginEventID,
+				Action:           auditDelete,
+				Before:           before,
+			})
+			infof("  🗑 Deleted blocker %s\n", describeEvent(entry.After))
+
+		case auditUpdate:
+			if entry.Before == nil {
+				infof("  ⚠️ Previous content of blocker %s unknown, leaving it as is\n", eventID)
+				continue
+			}
+			before, _ := calendarService.Events.Get(entry.CalendarID, eventID).Do()
+			res, err := calendarService.Events.Update(entry.CalendarID, eventID, restorableEvent(entry.Before)).Do()
+			if err != nil {
+				run.fail(err, "Error restoring blocker event %s", eventID)
+				continue
+			}
+			// The restored blocker no longer matches the recorded content: an
+			// empty last_updated and content_hash make the next sync check it,
+			// and the new blocker_updated keeps it from looking edited by hand
+			err = inTransaction(db, func(tx *sql.Tx) error {
+				_, err := tx.Exec("UPDATE blocker_events SET last_updated = '', content_hash = '', blocker_updated = ? WHERE event_id = ? AND calendar_id = ?",
+					res.Updated, eventID, entry.CalendarID)
+				if err != nil {
+					return err
+				}
+				recordAudit(tx, auditEntry{
+					AccountName:      entry.AccountName,
+					CalendarID:       entry.CalendarID,
+					EventID:          eventID,
+					OriginCalendarID: entry.OriginCalendarID,
+					OriginEventID:    entry.OriginEventID,
+					Action:           auditUpdate,
+					Before:           before,
+					After:            res,
+				})
+				return nil
+			})
+			if err != nil {
+				run.fail(err, "Error recording restored blocker event %s", eventID)
+				continue
+			}
+			markUndone(db, run, entry, eventID)
+			run.BlockersUpdated++
+			infof("  ↩️ Restored blocker %s\n", describeEvent(res))
+
+		case auditDelete:
+			if entry.Before == nil {
+				infof("  ⚠️ Content of deleted blocker %s unknown, can't recreate it\n", eventID)
+				continue
+			}
+			res, err := calendarService.Events.Insert(entry.CalendarID, restorableEvent(entry.Before)).Do()
+			if err != nil {
+				run.fail(err, "Error recreating blocker event %s", eventID)
+				continue
+			}
+			renamed[entry.EventID] = res.Id
+			// Recreated whatever happens next, it mustn't be recreated twice
+			markUndone(db, run, entry, res.Id)
+			run.BlockersCreated++
+			recordAudit(db, auditEntry{
+				AccountName:      entry.AccountName,
+				CalendarID:       entry.CalendarID,
+				EventID:          res.Id,
+				OriginCalendarID: entry.OriginCalendarID,
+				OriginEventID:    entry.OriginEventID,
+				Action:           auditInsert,
+				After:            res,
+			})
+			if entry.OriginEventID != "" {
+				responseStatus := "accepted"
+				for _, attendee := range res.Attendees {
+					if attendee.Email == entry.CalendarID {
+						responseStatus = attendee.ResponseStatus
+					}
+				}
+				// An empty last_updated makes the next sync refresh the blocker
+				_, err = db.Exec(`INSERT OR REPLACE INTO blocker_events
+					(event_id, origin_calendar_id, calendar_id, account_name, origin_event_id, last_updated, response_status, content_hash, blocker_updated)
+					VALUES (?, ?, ?, ?, ?, '', ?, '', ?)`,
+					res.Id, entry.OriginCalendarID, entry.CalendarID, entry.AccountName, entry.OriginEventID, responseStatus, res.Updated)
+				if err != nil {
+					run.fail(err, "Error inserting blocker event %s into database", res.Id)
+					continue
+				}
+			}
+			infof("  ➕ Recreated blocker %s\n", describeEvent(res))
+		}
+	}
+
+	// A run is only undone once all of its changes are, until then undo
+	// can be run again to retry the rest
+	if run.Errors > 0 {
+		run.finish(db)
+		log.Printf("⚠️ Some changes of run %d couldn't be undone. Run `gcalsync undo %d` again to retry them\n", target.ID, target.ID)
+		return
+	}
+	_, err = db.Exec("UPDATE sync_runs SET undone_by = ? WHERE id = ?", run.ID, target.ID)
+	if err != nil {
+		run.fail(err, "Error marking run %d as undone", target.ID)
+	}
+	run.finish(db)
+	infof("Run %d undone\n", target.ID)
+}
+
+// undoneChanges returns which audit log entries were already reversed, with
+// the ID of the blocker after that.
+func undoneChanges(db *sql.DB, entries []auditEntry) map[int64]string {
+	undone := make(map[int64]string)
+	for _, entry := range entries {
+		var eventID string
+		err := db.QueryRow("SELECT event_id FROM undone_changes WHERE audit_id = ?", entry.ID).Scan(&eventID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("❌ Error retrieving undone changes: %v", err)
+		}
+		undone[entry.ID] = eventID
+	}
+	return undone
+}
+
+// markUndone records that an audit log entry was reversed, leaving the
+// blocker with eventID.
+func markUndone(db *sql.DB, run *runStats, entry auditEntry, eventID string) {
+	_, err := db.Exec("INSERT OR REPLACE INTO undone_changes (audit_id, run_id, event_id) VALUES (?, ?, ?)", entry.ID, run.ID, eventID)
+	if err != nil {
+		run.fail(err, "Error recording undone change to blocker %s", eventID)
+	}
+}
+
+// restorableEvent copies the content of an event snapshot, leaving out what
+// Google manages itself (IDs, etags, sequence numbers).
+func restorableEvent(snapshot *calendar.Event) *calendar.Event {
+	return &calendar.Event{
+		Summary:      snapshot.Summary,
+		Description:  snapshot.Description,
+		Location:     snapshot.Location,
+		Start:        snapshot.Start,
+		End:          snapshot.End,
+		Attendees:    snapshot.Attendees,
+		Visibility:   snapshot.Visibility,
+		Transparency: snapshot.Transparency,
+		ColorId:      snapshot.ColorId,
+		Reminders:    snapshot.Reminders,
+	}
+}